@@ -0,0 +1,494 @@
+package cajun
+
+import (
+	"strings"
+)
+
+// Node is an element in the parsed creole document tree.
+type Node interface {
+	Type() NodeType
+	Position() Pos // byte offset of the start of the node in the input
+}
+
+// NodeType identifies the kind of a Node.
+type NodeType int
+
+// Type returns itself, it makes embedding a NodeType in a node satisfy part of Node.
+func (t NodeType) Type() NodeType {
+	return t
+}
+
+const (
+	NodeDocument NodeType = iota
+	NodeParagraph
+	NodeHeading
+	NodeList
+	NodeListItem
+	NodeTable
+	NodeRow
+	NodeCell
+	NodeLink
+	NodeImage
+	NodeBold
+	NodeItalics
+	NodeNoWiki
+	NodeHorizontalRule
+	NodeLineBreak
+	NodeSoftBreak
+	NodeText
+	NodeTOC
+	NodeHTML
+	NodeSuperscript
+	NodeSubscript
+	NodeMonospace
+	NodeUnderline
+	NodeDefinitionList
+	NodeDefinitionTerm
+	NodeDefinition
+	NodeIndent
+	NodeBlockquote
+)
+
+// Pos is a byte offset into the original input.
+type Pos int
+
+// Position returns itself, it makes embedding a Pos in a node satisfy part of Node.
+func (p Pos) Position() Pos {
+	return p
+}
+
+// Container is implemented by nodes that hold child nodes.
+type Container interface {
+	Node
+	Children() []Node
+}
+
+// Parent holds the children of a container node.
+type Parent struct {
+	Nodes   []Node
+	pending []string // text following the last node, a Text, joined to it by mergeText
+}
+
+// Children returns the child nodes.
+func (p *Parent) Children() []Node {
+	return p.Nodes
+}
+
+// appendChild adds n as the last child, merging adjacent text so a run of text is a single node.
+// The text is collected until mergeText, so a long run is copied once rather than on every append.
+func (p *Parent) appendChild(n Node) {
+	if t, ok := n.(*Text); ok && len(p.Nodes) > 0 {
+		if _, ok := p.Nodes[len(p.Nodes)-1].(*Text); ok {
+			p.pending = append(p.pending, t.Text)
+			return
+		}
+	}
+	p.mergeText()
+	p.Nodes = append(p.Nodes, n)
+}
+
+// mergeText joins the text collected by appendChild to the last child, it is called when the container is closed.
+func (p *Parent) mergeText() {
+	if len(p.pending) == 0 {
+		return
+	}
+	last := p.Nodes[len(p.Nodes)-1].(*Text)
+	last.Text += strings.Join(p.pending, "")
+	p.pending = nil
+}
+
+// Document is the root of a parsed creole document.
+type Document struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Paragraph is a block of inline content.
+type Paragraph struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Heading is a = heading =, Level is between 1 and 6.
+// ID is unique within the document, it is only set when heading ids are enabled in the Options.
+type Heading struct {
+	NodeType
+	Pos
+	Parent
+	Level int
+	ID    string
+}
+
+// List is an ordered (#) or unordered (*) list, its children are ListItems.
+type List struct {
+	NodeType
+	Pos
+	Parent
+	Ordered bool
+}
+
+// ListItem is a single entry of a List, it may contain a nested List.
+type ListItem struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// DefinitionList is a list of ; terms and their : definitions, its children are DefinitionTerms and Definitions.
+// It is one of the creole additions, enabled with ExtensionDefinitionLists.
+type DefinitionList struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// DefinitionTerm is a ; term of a DefinitionList, the Definitions following it define it.
+type DefinitionTerm struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Definition is a : definition of the DefinitionTerm before it.
+type Definition struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Indent is a run of : indented lines, its children are the inline content of the lines and any Indent nested in it, e.g. by ::.
+// It is one of the creole additions, enabled with ExtensionIndent.
+type Indent struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Blockquote is a run of > quoted lines, its children are the inline content of the lines and any Blockquote nested in it, e.g. by >>.
+// It is enabled with ExtensionBlockquotes.
+type Blockquote struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Table is a | table |, its children are Rows.
+type Table struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// hasHead checks if the first row of t is a header row
+func (t *Table) hasHead() bool {
+	if len(t.Nodes) == 0 {
+		return false
+	}
+	row, ok := t.Nodes[0].(*Row)
+	return ok && row.Header
+}
+
+// Row is a row of a Table, its children are Cells.
+// Header is set on the first row of a table when all of its cells are headers, making it the head of the table.
+type Row struct {
+	NodeType
+	Pos
+	Parent
+	Header bool
+}
+
+// Cell is a |= header | or | data | cell of a Row.
+// Align and Colspan are only set with ExtensionTableCells.
+type Cell struct {
+	NodeType
+	Pos
+	Parent
+	Header  bool
+	Align   Align
+	Colspan int // the number of columns the cell spans, 0 for one
+}
+
+// Align is the horizontal alignment of the content of a table cell.
+type Align int
+
+const (
+	AlignDefault Align = iota
+	AlignLeft
+	AlignCenter
+	AlignRight
+)
+
+func (a Align) String() string {
+	switch a {
+	case AlignLeft:
+		return "left"
+	case AlignCenter:
+		return "center"
+	case AlignRight:
+		return "right"
+	}
+	return ""
+}
+
+// Link is a [[location|text]] or a free link, its children are the link text.
+// Internal is set for links to wiki pages, i.e. [[Page Name]] rather than [[http://example.com]].
+type Link struct {
+	NodeType
+	Pos
+	Parent
+	Location string
+	Internal bool
+}
+
+// Image is a {{location|alt}} image.
+type Image struct {
+	NodeType
+	Pos
+	Location string
+	Alt      string
+}
+
+// Bold is **strong** text.
+type Bold struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Italics is //emphasized// text.
+type Italics struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Superscript is ^^raised^^ text, one of the creole additions.
+type Superscript struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Subscript is ,,lowered,, text, one of the creole additions.
+type Subscript struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Monospace is ##fixed width## text, one of the creole additions.
+// Unlike a nowiki, the text is still interpreted as creole.
+type Monospace struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// Underline is __underlined__ text, one of the creole additions.
+type Underline struct {
+	NodeType
+	Pos
+	Parent
+}
+
+// NoWiki is {{{ preformatted }}} text that is not interpreted as creole.
+// Block is set when the {{{ and }}} are on lines of their own, otherwise the nowiki is inline within a paragraph.
+// Lang is the language of the code in a block, e.g. go for {{{#!go, empty when it isn't given.
+type NoWiki struct {
+	NodeType
+	Pos
+	Text  string
+	Block bool
+	Lang  string
+}
+
+// HorizontalRule is a ---- line.
+type HorizontalRule struct {
+	NodeType
+	Pos
+}
+
+// LineBreak is a forced \\ line break.
+type LineBreak struct {
+	NodeType
+	Pos
+}
+
+// SoftBreak is a single newline within a block, which joins the lines either side of it.
+type SoftBreak struct {
+	NodeType
+	Pos
+}
+
+// Text is plain text.
+type Text struct {
+	NodeType
+	Pos
+	Text string
+}
+
+// TOC is a <<toc>> placeholder, replaced by the outline of the document when Options.TOC is set.
+type TOC struct {
+	NodeType
+	Pos
+	Entries []*TOCEntry
+}
+
+// HTML is markup written to the output as it is, it is only ever made by macros, see NewHTML.
+type HTML struct {
+	NodeType
+	Pos
+	HTML string
+}
+
+// NewText returns a Text node, for macros to return.
+func NewText(text string) *Text {
+	return newText(0, text)
+}
+
+// NewHTML returns an HTML node, for macros to return. html isn't escaped, so it must be trusted.
+func NewHTML(html string) *HTML {
+	return &HTML{NodeType: NodeHTML, HTML: html}
+}
+
+// Walk traverses the tree rooted at n depth first, calling fn for each node.
+// The children of a node are skipped when fn returns false.
+func Walk(n Node, fn func(Node) bool) {
+	if !fn(n) {
+		return
+	}
+	if c, ok := n.(Container); ok {
+		for _, child := range c.Children() {
+			Walk(child, fn)
+		}
+	}
+}
+
+// plainText returns the text of n and its children, without any markup
+func plainText(n Node) string {
+	var text []string
+	Walk(n, func(n Node) bool {
+		switch n := n.(type) {
+		case *Text:
+			text = append(text, n.Text)
+		case *NoWiki:
+			text = append(text, n.Text)
+		case *SoftBreak:
+			text = append(text, " ")
+		}
+		return true
+	})
+	return strings.Join(text, "")
+}
+
+func newTOC(pos Pos) *TOC {
+	return &TOC{NodeType: NodeTOC, Pos: pos}
+}
+
+func newDocument() *Document {
+	return &Document{NodeType: NodeDocument}
+}
+
+func newParagraph(pos Pos) *Paragraph {
+	return &Paragraph{NodeType: NodeParagraph, Pos: pos}
+}
+
+func newHeading(pos Pos, level int) *Heading {
+	return &Heading{NodeType: NodeHeading, Pos: pos, Level: level}
+}
+
+func newList(pos Pos, ordered bool) *List {
+	return &List{NodeType: NodeList, Pos: pos, Ordered: ordered}
+}
+
+func newListItem(pos Pos) *ListItem {
+	return &ListItem{NodeType: NodeListItem, Pos: pos}
+}
+
+func newDefinitionList(pos Pos) *DefinitionList {
+	return &DefinitionList{NodeType: NodeDefinitionList, Pos: pos}
+}
+
+func newDefinitionTerm(pos Pos) *DefinitionTerm {
+	return &DefinitionTerm{NodeType: NodeDefinitionTerm, Pos: pos}
+}
+
+func newDefinition(pos Pos) *Definition {
+	return &Definition{NodeType: NodeDefinition, Pos: pos}
+}
+
+func newIndent(pos Pos) *Indent {
+	return &Indent{NodeType: NodeIndent, Pos: pos}
+}
+
+func newBlockquote(pos Pos) *Blockquote {
+	return &Blockquote{NodeType: NodeBlockquote, Pos: pos}
+}
+
+func newTable(pos Pos) *Table {
+	return &Table{NodeType: NodeTable, Pos: pos}
+}
+
+func newRow(pos Pos) *Row {
+	return &Row{NodeType: NodeRow, Pos: pos}
+}
+
+func newCell(pos Pos, header bool) *Cell {
+	return &Cell{NodeType: NodeCell, Pos: pos, Header: header}
+}
+
+func newLink(pos Pos, location, text string) *Link {
+	l := &Link{NodeType: NodeLink, Pos: pos, Location: location}
+	l.appendChild(newText(pos, text))
+	return l
+}
+
+func newImage(pos Pos, location, alt string) *Image {
+	return &Image{NodeType: NodeImage, Pos: pos, Location: location, Alt: alt}
+}
+
+func newBold(pos Pos) *Bold {
+	return &Bold{NodeType: NodeBold, Pos: pos}
+}
+
+func newItalics(pos Pos) *Italics {
+	return &Italics{NodeType: NodeItalics, Pos: pos}
+}
+
+func newSuperscript(pos Pos) *Superscript {
+	return &Superscript{NodeType: NodeSuperscript, Pos: pos}
+}
+
+func newSubscript(pos Pos) *Subscript {
+	return &Subscript{NodeType: NodeSubscript, Pos: pos}
+}
+
+func newMonospace(pos Pos) *Monospace {
+	return &Monospace{NodeType: NodeMonospace, Pos: pos}
+}
+
+func newUnderline(pos Pos) *Underline {
+	return &Underline{NodeType: NodeUnderline, Pos: pos}
+}
+
+func newNoWiki(pos Pos) *NoWiki {
+	return &NoWiki{NodeType: NodeNoWiki, Pos: pos}
+}
+
+func newHorizontalRule(pos Pos) *HorizontalRule {
+	return &HorizontalRule{NodeType: NodeHorizontalRule, Pos: pos}
+}
+
+func newLineBreak(pos Pos) *LineBreak {
+	return &LineBreak{NodeType: NodeLineBreak, Pos: pos}
+}
+
+func newSoftBreak(pos Pos) *SoftBreak {
+	return &SoftBreak{NodeType: NodeSoftBreak, Pos: pos}
+}
+
+func newText(pos Pos, text string) *Text {
+	return &Text{NodeType: NodeText, Pos: pos, Text: text}
+}
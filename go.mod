@@ -0,0 +1,3 @@
+module github.com/m4tty/cajun
+
+go 1.23
@@ -0,0 +1,68 @@
+package cajun
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Slug makes an id from heading text, e.g. "Getting Started!" becomes "getting-started".
+// Letters and digits are kept, lower cased, and every other run of characters becomes a single -.
+// Text without any letters or digits becomes "section".
+func Slug(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+// headingIDs gives headings unique ids, in the order they appear in a document.
+// It is shared by the blocks of a streamed document, so ids are unique across the whole of it.
+type headingIDs struct {
+	id   func(text string) string // makes the id from the heading text, nil when headings don't get ids
+	used map[string]bool
+}
+
+func newHeadingIDs(opts Options) *headingIDs {
+	ids := &headingIDs{id: opts.HeadingID, used: make(map[string]bool)}
+	if ids.id == nil && (opts.HeadingIDs || opts.TOC) {
+		ids.id = Slug
+	}
+	return ids
+}
+
+// unique returns the id for text, adding -1, -2, ... to ids that have already been used
+func (ids *headingIDs) unique(text string) string {
+	base := ids.id(text)
+	id := base
+	for n := 1; ids.used[id]; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	ids.used[id] = true
+	return id
+}
+
+// assign sets the ID of each heading in doc that doesn't have one
+func (ids *headingIDs) assign(doc *Document) {
+	if ids == nil || ids.id == nil {
+		return
+	}
+	for _, n := range doc.Nodes {
+		if h, ok := n.(*Heading); ok && h.ID == "" {
+			h.ID = ids.unique(strings.TrimSpace(plainText(h)))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package cajun
+
+import (
+	"strings"
+)
+
+// Highlighter colours the code of a nowiki block tagged with a language, e.g. {{{#!go or <<code lang=go>>.
+// The html it returns is written as is, so it must escape the code itself.
+// Returning an error writes the code without highlighting, and the error is reported as a Warning.
+type Highlighter interface {
+	Highlight(lang, code string) (html string, err error)
+}
+
+// HighlighterFunc adapts a function to a Highlighter.
+type HighlighterFunc func(lang, code string) (string, error)
+
+// Highlight calls f(lang, code).
+func (f HighlighterFunc) Highlight(lang, code string) (string, error) {
+	return f(lang, code)
+}
+
+// isLang checks if s can be the language of a nowiki block, e.g. go, c++ or objective-c
+func isLang(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '+' || r == '-' || r == '_' || r == '.' || r == '#') {
+			return false
+		}
+	}
+	return true
+}
+
+// noWikiLang returns the language following the {{{ opening a nowiki block, i.e. go for {{{#!go.
+// ok is false when rest, the remainder of the {{{ line, is neither blank nor a language.
+func noWikiLang(rest string) (lang string, ok bool) {
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return "", true
+	}
+	if strings.HasPrefix(rest, "#!") && isLang(rest[2:]) {
+		return rest[2:], true
+	}
+	return "", false
+}
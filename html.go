@@ -0,0 +1,373 @@
+package cajun
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// DefaultAllowedSchemes are the url schemes links and images may use when an HTMLRenderer doesn't set its own.
+var DefaultAllowedSchemes = []string{"http", "https", "ftp", "mailto"}
+
+// PermalinkClass is the css class of the links HTMLRenderer adds to headings when Permalinks is set.
+const PermalinkClass = "permalink"
+
+// IndentClass is the css class of the div HTMLRenderer writes for indented lines.
+const IndentClass = "indent"
+
+// errNoImageSource rejects an image its ImageResolver didn't return a source for
+var errNoImageSource = errors.New("no image source")
+
+// TOCClass is the css class of the list HTMLRenderer writes for a table of contents.
+const TOCClass = "toc"
+
+// HTMLRenderer is the Renderer used by Transform, it writes html.
+// All text and attributes are escaped, so the output is safe to embed in a page.
+type HTMLRenderer struct {
+	// Unsafe allows links and images to use any url scheme, ignoring AllowedSchemes.
+	Unsafe bool
+
+	// AllowedSchemes lists the url schemes (e.g. "https") that links and images may use.
+	// Urls without a scheme, i.e. relative urls, are always allowed. It applies to external links and images,
+	// and to the urls of internal links from the LinkResolver, without one internal links are always relative.
+	// A link to any other scheme is written as plain text, an image as its alt text.
+	// When nil DefaultAllowedSchemes is used.
+	AllowedSchemes []string
+
+	// LinkResolver, when set, maps internal link targets to hrefs.
+	LinkResolver LinkResolver
+
+	// MissingLinkClass is the css class of links to missing pages, when empty DefaultMissingLinkClass is used.
+	MissingLinkClass string
+
+	// ImageResolver, when set, maps image locations to their sources.
+	ImageResolver ImageResolver
+
+	// Warn, when set, is called with rejected images.
+	Warn func(Warning)
+
+	// Permalinks writes a link to each heading with an id inside it, with the PermalinkClass css class.
+	Permalinks bool
+
+	// HardWrap writes newlines within a paragraph as <br />.
+	HardWrap bool
+
+	// Highlighter, when set, writes the code of nowiki blocks that have a language.
+	Highlighter Highlighter
+}
+
+// Document writes nothing, the html is a fragment to be placed in a page.
+func (h *HTMLRenderer) Document(w io.Writer, n *Document, entering bool) {
+}
+
+func (h *HTMLRenderer) Paragraph(w io.Writer, n *Paragraph, entering bool) {
+	h.tag(w, "p", entering)
+}
+
+// Heading writes the id of the heading, if it has one, and its permalink when Permalinks is set.
+func (h *HTMLRenderer) Heading(w io.Writer, n *Heading, entering bool) {
+	switch {
+	case entering && n.ID != "":
+		fmt.Fprintf(w, "<h%d id=\"%s\">", n.Level, html.EscapeString(n.ID))
+	case !entering && n.ID != "" && h.Permalinks:
+		fmt.Fprintf(w, "<a class=\"%s\" href=\"#%s\">&para;</a></h%d>", PermalinkClass, html.EscapeString(n.ID), n.Level)
+	default:
+		h.tag(w, fmt.Sprintf("h%d", n.Level), entering)
+	}
+}
+
+func (h *HTMLRenderer) List(w io.Writer, n *List, entering bool) {
+	if n.Ordered {
+		h.tag(w, "ol", entering)
+	} else {
+		h.tag(w, "ul", entering)
+	}
+}
+
+func (h *HTMLRenderer) ListItem(w io.Writer, n *ListItem, entering bool) {
+	h.tag(w, "li", entering)
+}
+
+func (h *HTMLRenderer) DefinitionList(w io.Writer, n *DefinitionList, entering bool) {
+	h.tag(w, "dl", entering)
+}
+
+func (h *HTMLRenderer) DefinitionTerm(w io.Writer, n *DefinitionTerm, entering bool) {
+	h.tag(w, "dt", entering)
+}
+
+func (h *HTMLRenderer) Definition(w io.Writer, n *Definition, entering bool) {
+	h.tag(w, "dd", entering)
+}
+
+// Indent writes a div with the IndentClass css class.
+func (h *HTMLRenderer) Indent(w io.Writer, n *Indent, entering bool) {
+	if entering {
+		io.WriteString(w, "<div class=\""+IndentClass+"\">")
+	} else {
+		io.WriteString(w, "</div>")
+	}
+}
+
+func (h *HTMLRenderer) Blockquote(w io.Writer, n *Blockquote, entering bool) {
+	h.tag(w, "blockquote", entering)
+}
+
+// Table writes a table, the rows following a header row are in a <tbody>.
+func (h *HTMLRenderer) Table(w io.Writer, n *Table, entering bool) {
+	if !entering && n.hasHead() {
+		io.WriteString(w, "</tbody>")
+	}
+	h.tag(w, "table", entering)
+}
+
+// Row writes a row, a header row in a <thead>.
+func (h *HTMLRenderer) Row(w io.Writer, n *Row, entering bool) {
+	if n.Header && entering {
+		io.WriteString(w, "<thead>")
+	}
+	h.tag(w, "tr", entering)
+	if n.Header && !entering {
+		io.WriteString(w, "</thead><tbody>")
+	}
+}
+
+// Cell writes a th or td, with the colspan and a text-align style when they are set.
+func (h *HTMLRenderer) Cell(w io.Writer, n *Cell, entering bool) {
+	name := "td"
+	if n.Header {
+		name = "th"
+	}
+	if !entering || n.Colspan < 2 && n.Align == AlignDefault {
+		h.tag(w, name, entering)
+		return
+	}
+	io.WriteString(w, "<"+name)
+	if n.Colspan > 1 {
+		fmt.Fprintf(w, " colspan=\"%d\"", n.Colspan)
+	}
+	if n.Align != AlignDefault {
+		fmt.Fprintf(w, " style=\"text-align: %s\"", n.Align)
+	}
+	io.WriteString(w, ">")
+}
+
+func (h *HTMLRenderer) Link(w io.Writer, n *Link, entering bool) {
+	href, exists := n.Location, true
+	if n.Internal && h.LinkResolver == nil {
+		// a page name isn't a url, the Help of Help:Contents isn't a scheme
+		href = relativeLink(n.Location)
+	} else {
+		if n.Internal {
+			href, exists = h.LinkResolver.ResolveLink(n.Location)
+		}
+		if !h.isAllowed(href) {
+			return
+		}
+	}
+	if entering {
+		class := ""
+		if !exists {
+			class = h.MissingLinkClass
+			if class == "" {
+				class = DefaultMissingLinkClass
+			}
+			class = " class=\"" + html.EscapeString(class) + "\""
+		}
+		io.WriteString(w, "<a href=\""+html.EscapeString(href)+"\""+class+">")
+	} else {
+		io.WriteString(w, "</a>")
+	}
+}
+
+func (h *HTMLRenderer) Bold(w io.Writer, n *Bold, entering bool) {
+	h.tag(w, "strong", entering)
+}
+
+func (h *HTMLRenderer) Italics(w io.Writer, n *Italics, entering bool) {
+	h.tag(w, "em", entering)
+}
+
+func (h *HTMLRenderer) Superscript(w io.Writer, n *Superscript, entering bool) {
+	h.tag(w, "sup", entering)
+}
+
+func (h *HTMLRenderer) Subscript(w io.Writer, n *Subscript, entering bool) {
+	h.tag(w, "sub", entering)
+}
+
+func (h *HTMLRenderer) Monospace(w io.Writer, n *Monospace, entering bool) {
+	h.tag(w, "tt", entering)
+}
+
+func (h *HTMLRenderer) Underline(w io.Writer, n *Underline, entering bool) {
+	h.tag(w, "u", entering)
+}
+
+func (h *HTMLRenderer) Image(w io.Writer, n *Image) {
+	src := &ImageSource{URL: n.Location}
+	if h.ImageResolver != nil {
+		var err error
+		if src, err = h.ImageResolver.ResolveImage(n.Location); err == nil && src == nil {
+			err = errNoImageSource
+		} else if err == nil && !h.isAllowed(src.URL) {
+			err = fmt.Errorf("scheme of %q not allowed", src.URL)
+		}
+		if err != nil {
+			h.warn(n, fmt.Sprintf("image %q rejected: %v", n.Location, err))
+			io.WriteString(w, html.EscapeString(n.Alt))
+			return
+		}
+	}
+	if !h.isAllowed(src.URL) {
+		io.WriteString(w, html.EscapeString(n.Alt))
+		return
+	}
+	io.WriteString(w, "<img src=\""+html.EscapeString(src.URL)+"\"")
+	if src.SrcSet != "" {
+		io.WriteString(w, " srcset=\""+html.EscapeString(src.SrcSet)+"\"")
+	}
+	if src.Width > 0 {
+		fmt.Fprintf(w, " width=\"%d\"", src.Width)
+	}
+	if src.Height > 0 {
+		fmt.Fprintf(w, " height=\"%d\"", src.Height)
+	}
+	io.WriteString(w, " alt=\""+html.EscapeString(n.Alt)+"\" />")
+}
+
+// NoWiki writes a block nowiki as <pre> and an inline one as <tt>.
+// A block with a language is written as <pre><code class="language-go">, highlighted by the Highlighter when there is one.
+func (h *HTMLRenderer) NoWiki(w io.Writer, n *NoWiki) {
+	if n.Block && n.Lang != "" {
+		io.WriteString(w, "<pre><code class=\"language-"+html.EscapeString(n.Lang)+"\">"+h.highlight(n)+"</code></pre>")
+	} else if n.Block {
+		io.WriteString(w, "<pre>"+html.EscapeString(n.Text)+"</pre>")
+	} else {
+		io.WriteString(w, "<tt>"+html.EscapeString(n.Text)+"</tt>")
+	}
+}
+
+// highlight returns the html of the code in n
+func (h *HTMLRenderer) highlight(n *NoWiki) string {
+	if h.Highlighter != nil {
+		code, err := h.Highlighter.Highlight(n.Lang, n.Text)
+		if err == nil {
+			return code
+		}
+		h.warn(n, fmt.Sprintf("highlighting %s failed: %v", n.Lang, err))
+	}
+	return html.EscapeString(n.Text)
+}
+
+func (h *HTMLRenderer) HorizontalRule(w io.Writer, n *HorizontalRule) {
+	io.WriteString(w, "<hr>")
+}
+
+func (h *HTMLRenderer) LineBreak(w io.Writer, n *LineBreak) {
+	io.WriteString(w, "<br />")
+}
+
+// SoftBreak keeps the newline, which the browser shows as a space, unless HardWrap is set.
+func (h *HTMLRenderer) SoftBreak(w io.Writer, n *SoftBreak) {
+	if h.HardWrap {
+		io.WriteString(w, "<br />")
+	} else {
+		io.WriteString(w, "\n")
+	}
+}
+
+func (h *HTMLRenderer) Text(w io.Writer, n *Text) {
+	io.WriteString(w, html.EscapeString(n.Text))
+}
+
+// TOC writes the table of contents as nested lists, with the TOCClass css class on the outer one.
+func (h *HTMLRenderer) TOC(w io.Writer, n *TOC) {
+	if len(n.Entries) == 0 {
+		return
+	}
+	io.WriteString(w, "<ul class=\""+TOCClass+"\">")
+	h.tocEntries(w, n.Entries)
+	io.WriteString(w, "</ul>")
+}
+
+func (h *HTMLRenderer) tocEntries(w io.Writer, entries []*TOCEntry) {
+	for _, e := range entries {
+		io.WriteString(w, "<li>")
+		if e.ID != "" {
+			io.WriteString(w, "<a href=\"#"+html.EscapeString(e.ID)+"\">"+html.EscapeString(e.Text)+"</a>")
+		} else {
+			io.WriteString(w, html.EscapeString(e.Text))
+		}
+		if len(e.Children) > 0 {
+			io.WriteString(w, "<ul>")
+			h.tocEntries(w, e.Children)
+			io.WriteString(w, "</ul>")
+		}
+		io.WriteString(w, "</li>")
+	}
+}
+
+// HTML writes the markup as it is.
+func (h *HTMLRenderer) HTML(w io.Writer, n *HTML) {
+	io.WriteString(w, n.HTML)
+}
+
+// tag writes the opening tag of name when entering, otherwise the closing tag
+func (h *HTMLRenderer) tag(w io.Writer, name string, entering bool) {
+	if entering {
+		io.WriteString(w, "<"+name+">")
+	} else {
+		io.WriteString(w, "</"+name+">")
+	}
+}
+
+// warn reports a problem with n, if anyone is listening
+func (h *HTMLRenderer) warn(n Node, msg string) {
+	if h.Warn != nil {
+		h.Warn(Warning{Pos: n.Position(), Msg: msg})
+	}
+}
+
+// isAllowed checks if the scheme of url, if it has one, is in the allowed schemes
+func (h *HTMLRenderer) isAllowed(url string) bool {
+	scheme, ok := urlScheme(url)
+	if !ok || h.Unsafe {
+		return true
+	}
+	allowed := h.AllowedSchemes
+	if allowed == nil {
+		allowed = DefaultAllowedSchemes
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlScheme returns the lower cased scheme of url, ok is false for relative urls.
+// Browsers ignore whitespace and control characters in a scheme (e.g. "java\tscript:"), so they are dropped here as well.
+func urlScheme(url string) (scheme string, ok bool) {
+	var b []rune
+	for _, r := range url {
+		switch {
+		case r <= ' ' || r == 0x7f:
+			continue
+		case r == ':':
+			return strings.ToLower(string(b)), len(b) > 0
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9', r == '+', r == '-', r == '.':
+			if len(b) == 0 {
+				return "", false
+			}
+		default:
+			return "", false
+		}
+		b = append(b, r)
+	}
+	return "", false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	_ "io/ioutil"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -24,11 +25,18 @@ type lexer struct {
 	start        int
 	pos          int
 	width        int
-	items        chan item
+	items        []item // emitted items waiting to be returned by nextItem
+	head         int    // index in items of the next item to return
 	lastType     itemType
 	lastLastType itemType
 	listDepth    int
 	breakCount   int // a count of \newlines emitted, since last list
+	extensions   Extension
+	schemes      []string // the url schemes of free links, DefaultFreeLinkSchemes when nil
+	inTerm       bool     // the line is a ; term whose definition hasn't started, with ExtensionDefinitionLists
+	lineEnd      int      // end of the line isPlaceholderStart last searched
+	closeAt      int      // the >> isPlaceholderStart found on that line, lineEnd when there is none
+	searchedFrom int      // where isPlaceholderStart last searched from
 	//consider storing a last "block" hit. different than last emit type, more course grained
 }
 
@@ -107,6 +115,22 @@ const (
 	itemNoWikiOpen
 	itemNoWikiText
 	itemWikiLineBreak
+	itemPlaceholder
+	itemPlaceholderBlockOpen
+	itemPlaceholderBlockBody
+	itemPlaceholderBlockClose
+	itemSuperscript
+	itemSubscript
+	itemMonospace
+	itemUnderline
+	itemNoWikiBlockOpen
+	itemNoWikiBlockClose
+	itemWikiWord
+	itemDefinitionList
+	itemDefinitionTerm
+	itemDefinition
+	itemIndent
+	itemBlockquote
 )
 
 //lex constructs a new lexer for the supplied input
@@ -115,28 +139,31 @@ func lex(name, input string) *lexer {
 		name:  name,
 		input: input,
 		state: lexText,
-		items: make(chan item, 2),
 	}
 	return l
 
 }
 
-// nextItem returns the next item (token) from the input
+// nextItem returns the next item (token) from the input.
+// State functions are run until at least one item has been emitted, after the EOF or an error every call returns EOF.
 func (l *lexer) nextItem() item {
-	for {
-		select {
-		case item := <-l.items:
-			return item
-		default:
-			//	fmt.Println(l.state)
-			if l.state != nil {
-				l.state = l.state(l)
-			}
+	for l.head == len(l.items) {
+		if l.state == nil {
+			return item{itemEOF, l.pos, ""}
 		}
+		//everything emitted so far has been returned, reuse the buffer
+		l.items = l.items[:0]
+		l.head = 0
+		l.state = l.state(l)
 	}
-	panic("bad")
+	item := l.items[l.head]
+	l.head++
+	return item
 }
 
+// DefaultFreeLinkSchemes are the url schemes of the urls in the text that are linked, when the options don't set their own.
+var DefaultFreeLinkSchemes = []string{"http", "https", "ftp", "mailto"}
+
 const (
 	italicsDelimToken    = "//"
 	wikiLineBreakToken   = "\\\\"
@@ -154,14 +181,35 @@ const (
 // lexText is the main control function, delegates to underlying lex stateFns depending on current pos of input
 func lexText(l *lexer) stateFn {
 	for {
+		if l.isInsideWord() {
+			//nothing starts in the middle of a word, skip the checks below
+			l.pos++
+			continue
+		}
 		if strings.HasPrefix(l.input[l.pos:], "~") {
 			l.emitAnyPreviousText()
 			return lexEscape
 		}
-		if strings.HasPrefix(l.input[l.pos:], "//") {
+		if strings.HasPrefix(l.input[l.pos:], "//") && !l.isAfterURLScheme() {
 			l.emitAnyPreviousText()
 			return lexItalics
 		}
+		if l.extensions&ExtensionAdditions != 0 {
+			if typ, delim := l.additionsDelim(); typ != itemUnset {
+				l.emitAnyPreviousText()
+				l.pos += len(delim)
+				l.emit(typ)
+				return lexText
+			}
+		}
+		if l.extensions&ExtensionWikiWords != 0 && l.isWordStart() {
+			if n := wikiWordLength(l.input[l.pos:]); n > 0 {
+				l.emitAnyPreviousText()
+				l.pos += n
+				l.emit(itemWikiWord)
+				return lexText
+			}
+		}
 		if strings.HasPrefix(l.input[l.pos:], "\\\\") {
 			l.emitAnyPreviousText()
 			return lexWikiLineBreak
@@ -174,11 +222,19 @@ func lexText(l *lexer) stateFn {
 			l.emitAnyPreviousText()
 			return lexHeading
 		}
+		if strings.HasPrefix(l.input[l.pos:], placeholderDelimLeftToken) && l.isPlaceholderStart() {
+			l.emitAnyPreviousText()
+			return lexPlaceholder
+		}
 		if strings.HasPrefix(l.input[l.pos:], "[[") {
 			//l.emitAnyPreviousText()
 			return lexLink
 		}
 		if strings.HasPrefix(l.input[l.pos:], "{{{") {
+			if l.isNoWikiBlockStart() {
+				l.emitAnyPreviousText()
+				return lexNoWikiBlock
+			}
 			//l.emitAnyPreviousText()
 			return lexInsideNoWiki
 		}
@@ -186,7 +242,7 @@ func lexText(l *lexer) stateFn {
 			//l.emitAnyPreviousText()
 			return lexImage
 		}
-		if strings.HasPrefix(l.input[l.pos:], "http://") {
+		if l.isFreeLinkStart() {
 			l.emitAnyPreviousText()
 			return lexFreeLink
 		}
@@ -204,6 +260,16 @@ func lexText(l *lexer) stateFn {
 			l.emitAnyPreviousText()
 			return lexTable
 		}
+		if typ, n := l.nestingMarker(); n > 0 {
+			l.emitAnyPreviousText()
+			l.pos += n
+			l.emit(typ)
+			return lexText
+		}
+		if l.extensions&ExtensionDefinitionLists != 0 && l.isDefinitionListStart() {
+			l.emitAnyPreviousText()
+			return lexDefinitionList
+		}
 		if strings.HasPrefix(l.input[l.pos:], horizontalRuleToken) {
 			return lexHorizontalRule
 		}
@@ -233,8 +299,9 @@ func (l *lexer) next() rune {
 	return r
 }
 
+//errorf emits an error item, positioned at the start of the current item, and ends the scan
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- item{1, l.start, fmt.Sprintf(format, args...)}
+	l.items = append(l.items, item{itemError, l.start, fmt.Sprintf(format, args...)})
 	return nil
 }
 
@@ -249,16 +316,20 @@ func lexImage(l *lexer) stateFn {
 		l.pos += l.width
 		l.emit(itemImage)
 	} else {
-		//support implicit close (i.e. close at new line)
-		l.next()
+		l.emitAnyPreviousText()
+		return l.errorf("unclosed image, expected }} before the end of the line")
 	}
 	return lexText
 }
 
 //lexNoWikiText emits a noWikiText token of {{{ asdfasdf }}}
+//braces preceding the }}} belong to the text, so {{{a}}}}} is the text a}}
 func lexNoWikiText(l *lexer) stateFn {
 
 	length := getTextLength(l.input, l.pos, "}}}")
+	for strings.HasPrefix(l.input[l.pos+length+1:], "}}}") {
+		length++
+	}
 	l.width = length
 	l.pos += l.width
 	l.emit(itemNoWikiText)
@@ -281,8 +352,8 @@ func lexInsideNoWiki(l *lexer) stateFn {
 			return lexNoWikiText
 		}
 	} else {
-		//support implicit close (i.e. close at new line)
-		l.next()
+		l.emitAnyPreviousText()
+		return l.errorf("unclosed nowiki, expected }}}")
 	}
 	return lexText
 }
@@ -303,14 +374,68 @@ func lexNoWikiRight(l *lexer) stateFn {
 	l.emit(itemNoWikiClose)
 	return lexText
 }
+
+//isNoWikiBlockStart checks if the {{{ at the current position is on a line of its own, optionally followed by a language, e.g. {{{#!go, and there is a }}} line closing it
+func (l *lexer) isNoWikiBlockStart() bool {
+	if !l.isPrecededByWhitespace(l.pos) {
+		return false
+	}
+	rest := l.input[l.pos+len("{{{"):]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	if _, ok := noWikiLang(rest); !ok {
+		return false
+	}
+	return findNoWikiCloseLine(l.input, l.pos) >= 0
+}
+
+//lexNoWikiBlock emits the {{{ line starting a preformatted block, the lines up to the }}} line closing it, and the }}}
+func lexNoWikiBlock(l *lexer) stateFn {
+	l.pos += len("{{{")
+	if strings.HasPrefix(l.input[l.pos:], "#!") {
+		l.pos += strings.IndexAny(l.input[l.pos:]+"\n", " \t\r\n")
+	}
+	l.emit(itemNoWikiBlockOpen)
+	l.pos = findNoWikiCloseLine(l.input, l.pos)
+	l.emit(itemNoWikiText)
+	l.pos += len("}}}")
+	l.emit(itemNoWikiBlockClose)
+	return lexText
+}
+
+//findNoWikiCloseLine returns the start of the first line after pos that is }}}, or -1
+//unlike the close of a block placeholder the }}} can't be indented, an indented }}} is part of the preformatted text
+func findNoWikiCloseLine(input string, pos int) int {
+	for {
+		i := strings.IndexByte(input[pos:], '\n')
+		if i < 0 {
+			return -1
+		}
+		pos += i + 1
+		line := input[pos:]
+		if j := strings.IndexByte(line, '\n'); j >= 0 {
+			line = line[:j]
+		}
+		if strings.TrimRight(line, " \t\r") == "}}}" {
+			return pos
+		}
+	}
+}
+
 func lexNewLine(l *lexer) stateFn {
+	l.inTerm = false
 
 	if l.isPrecededByWhitespace(l.pos) {
 		// we just encountered an empty line
 		l.resetBreaksSinceList()
 		l.resetListDepth()
 	}
-	l.width = len("\n")
+	if strings.HasPrefix(l.input[l.pos:], "\r\n") {
+		l.width = len("\r\n")
+	} else {
+		l.width = len("\n")
+	}
 	l.pos += l.width
 	l.emit(itemNewLine) //TODO: reintroduce if needed
 
@@ -336,33 +461,29 @@ func lexWikiLineBreak(l *lexer) stateFn {
 	return lexText
 }
 
+//isInsideWord checks if the next byte is an ascii letter or digit following another, which can't start any markup
+func (l *lexer) isInsideWord() bool {
+	return l.pos > l.start && l.pos < len(l.input) && isAlphaNumeric(l.input[l.pos]) && isAlphaNumeric(l.input[l.pos-1])
+}
+
+//isWordStart checks if the current position doesn't follow a letter or digit
+func (l *lexer) isWordStart() bool {
+	r, _ := utf8.DecodeLastRuneInString(l.input[:l.pos])
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+//isPrecededByWhitespace checks if there is only whitespace between the start of the line and startPos
 func (l *lexer) isPrecededByWhitespace(startPos int) bool {
-	whitespaceOnly := false
-	i := startPos
-	//handle edge case in which we have just started lexing and we have text e.g. "test test ----"
-	if l.lastType == itemUnset {
-		if startPos > 0 {
-			for i >= 0 {
-				if strings.HasPrefix(l.input[i:], " ") || strings.HasPrefix(l.input[i:], "\t") {
-					whitespaceOnly = true
-				} else {
-					if strings.HasPrefix(l.input[i:], "\n") {
-						whitespaceOnly = true
-					} else {
-						whitespaceOnly = false
-					}
-					break
-				}
-				i--
-			}
-		} else {
+	for i := startPos - 1; i >= 0; i-- {
+		switch l.input[i] {
+		case ' ', '\t':
+			continue
+		case '\n', '\r':
 			return true
 		}
+		return false
 	}
-	if l.lastType == itemNewLine || (l.lastType == itemSpaceRun && l.lastLastType == itemNewLine) || (l.lastType == itemSpaceRun && l.lastLastType == itemUnset) {
-		return true
-	}
-	return whitespaceOnly
+	return true
 }
 
 //isFollowedByWhiteSpace checks if the currentPos has only whitespace after it up until a new line
@@ -399,16 +520,27 @@ func lexHorizontalRule(l *lexer) stateFn {
 	return lexText
 }
 
+//lexEscapeText emits the rune following a ~ as text, or the whole of a free link or WikiWord so it isn't linked
 func lexEscapeText(l *lexer) stateFn {
-	l.pos += 1
-	//TODO: if followed by a link, we need to escape the entire link, which would happpen just by jumping one char forward
+	if l.isFreeLinkStart() {
+		l.pos += getFreeLinkLength(l.input, l.pos)
+	} else if n := wikiWordLength(l.input[l.pos:]); n > 0 && l.extensions&ExtensionWikiWords != 0 {
+		l.pos += n
+	} else {
+		l.next()
+	}
 	l.emit(itemEscapeText)
 	return lexText
 }
 
-//lexEscape emits a itemEscape token when a ~ tilda is encountered. it also emits one the next "escaped" rune
+//lexEscape emits a itemEscape token when a ~ tilda is encountered. it also emits one the next "escaped" rune.
+//A ~ followed by whitespace, or at the end of the input, escapes nothing and is just text.
 func lexEscape(l *lexer) stateFn {
 	l.pos += len("~")
+	if r := l.peek(); r == eof || isSpace(r) || isEndOfLine(r) {
+		l.emit(itemEscapeText)
+		return lexText
+	}
 	l.emit(itemEscape)
 	return lexEscapeText
 }
@@ -429,39 +561,102 @@ func lexLink(l *lexer) stateFn {
 		l.pos += l.width
 		l.emit(itemLink)
 	} else {
-		//support implicit close (i.e. close at new line)
-		l.next()
+		l.emitAnyPreviousText()
+		return l.errorf("unclosed link, expected ]] before the end of the line")
 	}
 	return lexText
 }
+
+const (
+	placeholderDelimLeftToken  = "<<"
+	placeholderDelimRightToken = ">>"
+)
+
+// maxPlaceholderBodyLines is the most lines the body of a block placeholder can have.
+// The <</name>> closing it is only looked for that far ahead, by the lexer and by TransformTo.
+const maxPlaceholderBodyLines = 1000
+
+// additions are the delimiters of the creole additions inline markup
+var additions = []struct {
+	delim string
+	typ   itemType
+}{
+	{"^^", itemSuperscript},
+	{",,", itemSubscript},
+	{"##", itemMonospace},
+	{"__", itemUnderline},
+}
+
+//additionsDelim returns the type of the creole additions delimiter at the current position, e.g. ^^ for superscript, or itemUnset.
+//A run of # (or of # and *) at the start of a line followed by a space is a list item, not monospace.
+func (l *lexer) additionsDelim() (itemType, string) {
+	for _, a := range additions {
+		if strings.HasPrefix(l.input[l.pos:], a.delim) {
+			rest := strings.TrimLeft(l.input[l.pos:], "#*")
+			if a.typ == itemMonospace && l.isPrecededByWhitespace(l.pos) && rest != "" && isSpace(rune(rest[0])) {
+				return itemUnset, ""
+			}
+			return a.typ, a.delim
+		}
+	}
+	return itemUnset, ""
+}
+
+//lexPlaceholder emits a placeholder token of the whole <<name args>>, it must be closed on the same line.
+//When it is a registered macro on a line of its own, and a later line is just <</name>>, the lines between are its body,
+//and it is emitted as block open, body and close tokens instead. Otherwise the following lines are lexed as usual.
+func lexPlaceholder(l *lexer) stateFn {
+	l.pos += getTextLength(l.input, l.pos, placeholderDelimRightToken) + len(placeholderDelimRightToken)
+	name, _ := parsePlaceholder(l.input[l.start:l.pos])
+	if name != "" && lookupMacro(name) != nil && l.isPrecededByWhitespace(l.start) && l.isFollowedByWhiteSpace(l.pos) {
+		closeDelim := "<</" + name + placeholderDelimRightToken
+		if end := findCloseLine(l.input, l.pos, closeDelim); end >= 0 {
+			l.emit(itemPlaceholderBlockOpen)
+			l.pos = end
+			l.emit(itemPlaceholderBlockBody)
+			l.pos += strings.Index(l.input[l.pos:], closeDelim) + len(closeDelim)
+			l.emit(itemPlaceholderBlockClose)
+			return lexText
+		}
+	}
+	l.emit(itemPlaceholder)
+	return lexText
+}
+
+//findCloseLine returns the start of the first line after pos that is only closeDelim, ignoring surrounding whitespace,
+//or -1 if there isn't one within maxPlaceholderBodyLines lines
+func findCloseLine(input string, pos int, closeDelim string) int {
+	for n := 0; n <= maxPlaceholderBodyLines; n++ {
+		i := strings.IndexByte(input[pos:], '\n')
+		if i < 0 {
+			return -1
+		}
+		pos += i + 1
+		line := input[pos:]
+		if j := strings.IndexByte(line, '\n'); j >= 0 {
+			line = line[:j]
+		}
+		if strings.TrimSpace(line) == closeDelim {
+			return pos
+		}
+	}
+	return -1
+}
+
 func lexOrderedList(l *lexer) stateFn {
-	poundCount := 0
+	if l.listMarkerLength() > 0 {
+		return lexListItem
+	}
 	for isPound(l.peek()) {
-		poundCount++
 		l.next()
 	}
-	if isSpace(l.peek()) && l.isPrecededByWhitespace(l.pos-poundCount) {
-		if l.listDepth+1 == poundCount {
-			//this is a new list start
-			l.emit(itemListOrderedIncrease)
-			l.emit(itemListOrdered)
-			l.listDepth++
-			l.breakCount = 0
-		} else if l.listDepth == poundCount {
-			l.emit(itemListOrderedSameAsLast)
-			l.breakCount = 0
-		} else if l.listDepth != 0 && l.listDepth >= poundCount {
-			l.listDepth--
-			l.emit(itemListOrderedDecrease)
-			l.breakCount = 0
-		} else {
-			l.next()
-		}
-	}
 	return lexText
 }
 
 func lexAsterisk(l *lexer) stateFn {
+	if l.listMarkerLength() > 0 {
+		return lexListItem
+	}
 
 	asteriskCount := 0
 	for isAsterisk(l.peek()) {
@@ -469,34 +664,97 @@ func lexAsterisk(l *lexer) stateFn {
 		l.next()
 	}
 
-	//could be a list item begin or a list item
-	// if first of a depth (only incrementally from previous length)
-	//  then we are starting a new (maybe embedded list)
-	if isSpace(l.peek()) && l.isPrecededByWhitespace(l.pos-asteriskCount) {
-		if l.listDepth+1 == asteriskCount {
-			//this is a new list start
-			l.emit(itemListUnorderedIncrease)
-			l.emit(itemListUnordered)
-			l.listDepth++
-			l.breakCount = 0
-		} else if l.listDepth == asteriskCount {
-			l.emit(itemListUnorderedSameAsLast)
-			l.breakCount = 0
-		} else if l.listDepth != 0 && l.listDepth >= asteriskCount {
-			l.listDepth--
-			l.emit(itemListUnorderedDecrease)
-			l.breakCount = 0
-		} else {
-			//here we have 2 or more asterisks, at the beginning of a line (perhaps w/ whitespace), but out of the blue...
-			// so I think we will treat the first two as bold, then let the rest be text?
-			//adjust l.pos to be only 2 asterisks and emit bold
-			l.pos = l.pos - (asteriskCount - 2)
-			l.emit(itemBold)
-		}
+	if isSpace(l.peek()) && l.isPrecededByWhitespace(l.pos-asteriskCount) && asteriskCount > 2 {
+		//here we have 3 or more asterisks, at the beginning of a line (perhaps w/ whitespace), but out of the blue...
+		// so I think we will treat the first two as bold, then let the rest be text?
+		//adjust l.pos to be only 2 asterisks and emit bold
+		l.pos = l.pos - (asteriskCount - 2)
+		l.emit(itemBold)
+	} else if asteriskCount == 2 {
+		l.emit(itemBold)
+	}
+	return lexText
+}
+
+//listMarkerLength returns the length of the run of * and # starting a list item at the current position, or 0 if it doesn't start one.
+//The marker starts a line and is followed by a space, and the item is at most one level deeper than the last one.
+func (l *lexer) listMarkerLength() int {
+	n := 0
+	for l.pos+n < len(l.input) && (l.input[l.pos+n] == '*' || l.input[l.pos+n] == '#') {
+		n++
+	}
+	if n > l.listDepth+1 || l.pos+n == len(l.input) || !isSpace(rune(l.input[l.pos+n])) || !l.isPrecededByWhitespace(l.pos) {
+		return 0
+	}
+	return n
+}
+
+//lexListItem emits the marker of a list item, the kind of the item is given by the last * or #.
+//Each * or # of the marker is a level of nesting, so ** is an item of a list nested in another, and *# an item of an ordered list nested in an unordered one.
+func lexListItem(l *lexer) stateFn {
+	n := l.listMarkerLength()
+	l.pos += n
+	increase, item, same, decrease := itemListUnorderedIncrease, itemListUnordered, itemListUnorderedSameAsLast, itemListUnorderedDecrease
+	if l.input[l.pos-1] == '#' {
+		increase, item, same, decrease = itemListOrderedIncrease, itemListOrdered, itemListOrderedSameAsLast, itemListOrderedDecrease
+	}
+	switch {
+	case n == l.listDepth+1:
+		//this is a new list start
+		l.emit(increase)
+		l.emit(item)
+		l.listDepth++
+	case n == l.listDepth:
+		l.emit(same)
+	default:
+		l.listDepth = n
+		l.emit(decrease)
+	}
+	l.breakCount = 0
+	return lexText
+}
+
+//isDefinitionListStart checks for the ; of a term or the : of a definition, at the start of a line, a term can't be empty,
+//or for the : following whitespace that ends a term and starts its definition on the same line, e.g. ; term : definition
+func (l *lexer) isDefinitionListStart() bool {
+	switch {
+	case strings.HasPrefix(l.input[l.pos:], ";"):
+		return l.isPrecededByWhitespace(l.pos) && !l.isFollowedByWhiteSpace(l.pos+1)
+	case strings.HasPrefix(l.input[l.pos:], ":"):
+		return l.isPrecededByWhitespace(l.pos) || l.inTerm && l.pos > 0 && isSpace(rune(l.input[l.pos-1]))
+	}
+	return false
+}
+
+//nestingMarker returns the type and length of the run of : indenting, or > quoting, the line at the current position, or 0 if it doesn't start one.
+//Each : or > is a level of nesting, the markers are only seen at the start of a line with ExtensionIndent and ExtensionBlockquotes.
+func (l *lexer) nestingMarker() (itemType, int) {
+	typ, marker := itemIndent, byte(':')
+	switch {
+	case l.extensions&ExtensionIndent != 0 && strings.HasPrefix(l.input[l.pos:], ":"):
+	case l.extensions&ExtensionBlockquotes != 0 && strings.HasPrefix(l.input[l.pos:], ">"):
+		typ, marker = itemBlockquote, '>'
+	default:
+		return itemUnset, 0
+	}
+	if !l.isPrecededByWhitespace(l.pos) {
+		return itemUnset, 0
+	}
+	n := 0
+	for l.pos+n < len(l.input) && l.input[l.pos+n] == marker {
+		n++
+	}
+	return typ, n
+}
+
+//lexDefinitionList emits the ; starting a term or the : starting a definition
+func lexDefinitionList(l *lexer) stateFn {
+	l.inTerm = l.input[l.pos] == ';'
+	l.pos++
+	if l.inTerm {
+		l.emit(itemDefinitionTerm)
 	} else {
-		if asteriskCount == 2 {
-			l.emit(itemBold)
-		}
+		l.emit(itemDefinition)
 	}
 	return lexText
 }
@@ -518,28 +776,31 @@ func lexSpace(l *lexer) stateFn {
 	return lexText
 }
 
+//lexTable emits the | starting a row, a cell, or ending a row
+//A | at the start of a line, after any indentation, starts a row and its first cell. A | followed only by whitespace ends the row.
 func lexTable(l *lexer) stateFn {
-	if l.lastType == itemNewLine || l.lastType == itemUnset {
-		for isPipe(l.peek()) {
-			l.next()
-		}
+	if l.isPrecededByWhitespace(l.pos) {
+		l.next()
+		l.emit(itemTableRowStart)
 		if isEquals(l.peek()) {
 			l.next()
-			l.emit(itemTableRowStart)
+			l.cellFormat()
 			l.emit(itemTableHeaderItem)
 		} else {
-			l.emit(itemTableRowStart)
+			l.cellFormat()
 			l.emit(itemTableItem)
 		}
 	} else {
 		l.next()
 		if isEquals(l.peek()) {
 			l.next()
+			l.cellFormat()
 			l.emit(itemTableHeaderItem)
 		} else {
 			if l.isFollowedByWhiteSpace(l.pos) {
 				l.emit(itemTableRowEnd)
 			} else {
+				l.cellFormat()
 				l.emit(itemTableItem)
 			}
 		}
@@ -548,6 +809,52 @@ func lexTable(l *lexer) stateFn {
 
 }
 
+//cellFormat moves past the colspan and alignment markers at the start of a cell, with ExtensionTableCells, so they are part of the cell item
+//e.g. the <2> of |<2>Total, or the > of |= >Total. An alignment marker has to be followed by the content of the cell,
+//only a centered cell, |: a :|, can have whitespace around its content.
+func (l *lexer) cellFormat() {
+	if l.extensions&ExtensionTableCells == 0 {
+		return
+	}
+	rest := l.input[l.pos:]
+	if n := colspanLength(rest); n > 0 {
+		l.pos += n
+		rest = rest[n:]
+	}
+	i := len(rest) - len(strings.TrimLeft(rest, " \t"))
+	if i+1 < len(rest) && strings.IndexByte("<>:", rest[i]) >= 0 && strings.IndexByte(" \t\r\n|<", rest[i+1]) < 0 || isCenteredCell(rest[i:]) {
+		l.pos += i + 1
+	}
+}
+
+//isCenteredCell checks if the cell input starts with is :centered: content, ending with a :
+func isCenteredCell(input string) bool {
+	if !strings.HasPrefix(input, ":") {
+		return false
+	}
+	cell := input[1:]
+	if i := strings.IndexAny(cell, "|\r\n"); i >= 0 {
+		cell = cell[:i]
+	}
+	cell = strings.TrimRight(cell, " \t")
+	return strings.HasSuffix(cell, ":") && strings.TrimSpace(cell[:len(cell)-1]) != ""
+}
+
+//colspanLength returns the length of the <n> colspan input starts with, or 0
+func colspanLength(input string) int {
+	if !strings.HasPrefix(input, "<") {
+		return 0
+	}
+	i := 1
+	for i < len(input) && '0' <= input[i] && input[i] <= '9' {
+		i++
+	}
+	if i == 1 || i == len(input) || input[i] != '>' {
+		return 0
+	}
+	return i + 1
+}
+
 func lexHeading(l *lexer) stateFn {
 	headingCount := 0
 	isPrecededByWhiteSpaceOnly := l.isPrecededByWhitespace(l.pos)
@@ -581,21 +888,136 @@ func getTextLength(input string, currentPos int, closeChars string) int {
 		return len(input)
 	}
 }
+
+//getFreeLinkLength returns the length of the free link at currentPos, which ends at whitespace or the end of the input.
+//Trailing punctuation, and a ) that closes a ( outside of the link, are left out, so (see http://a.org/Go_(language)). links http://a.org/Go_(language)
 func getFreeLinkLength(input string, currentPos int) int {
-	i := strings.Index(input[currentPos:], " ")
-	link := input[currentPos : currentPos+i]
-	punctuation := ",.?!:;\"'"
-	for _, p := range punctuation {
-		if strings.HasSuffix(link, string(p)) {
-			i = i - len(string(p))
+	link := input[currentPos:]
+	if i := strings.IndexAny(link, " \t\r\n"); i >= 0 {
+		link = link[:i]
+	}
+	for link != "" {
+		last := link[len(link)-1]
+		if strings.IndexByte(",.?!:;\"'", last) < 0 && (last != ')' || strings.Count(link, "(") >= strings.Count(link, ")")) {
 			break
 		}
+		link = link[:len(link)-1]
 	}
-	if i >= 0 {
-		return i
-	} else {
-		return len(input)
+	return len(link)
+}
+
+//isFreeLinkStart checks if the current position starts a url that should be linked, e.g. http://example.com
+//The url has to have one of the free link schemes, and something following the scheme and any // after it.
+func (l *lexer) isFreeLinkStart() bool {
+	input := l.input[l.pos:]
+	if len(input) == 0 || !isLetter(input[0]) {
+		return false
+	}
+	n := 1
+	for n < len(input) && isSchemeChar(input[n]) {
+		n++
+	}
+	if n == len(input) || input[n] != ':' || !l.isFreeLinkScheme(input[:n]) {
+		return false
+	}
+	prefix := n + 1
+	if strings.HasPrefix(input[prefix:], "//") {
+		prefix += len("//")
+	}
+	return getFreeLinkLength(l.input, l.pos) > prefix
+}
+
+//freeLinkSchemes returns the url schemes of the urls in the text that are linked
+func (l *lexer) freeLinkSchemes() []string {
+	if l.schemes == nil {
+		return DefaultFreeLinkSchemes
 	}
+	return l.schemes
+}
+
+//isFreeLinkScheme checks if scheme is one of the free link schemes
+func (l *lexer) isFreeLinkScheme(scheme string) bool {
+	for _, s := range l.freeLinkSchemes() {
+		if strings.EqualFold(scheme, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//wikiWordLength returns the length of the WikiWord input starts with, or 0 if it doesn't start with one.
+//A WikiWord is two or more capitalised parts run together, e.g. FrontPage or RecentChanges2, and ends at the first rune that isn't a letter or digit.
+//Runs of capitals, as in HTTPServer, aren't WikiWords.
+func wikiWordLength(input string) int {
+	parts := 0
+	lower := false // the current part has something following its capital
+	n := 0
+	for n < len(input) {
+		r, w := utf8.DecodeRuneInString(input[n:])
+		if unicode.IsUpper(r) {
+			if parts > 0 && !lower {
+				return 0
+			}
+			parts++
+			lower = false
+		} else if unicode.IsLower(r) || unicode.IsDigit(r) {
+			if parts == 0 {
+				return 0
+			}
+			lower = true
+		} else {
+			break
+		}
+		n += w
+	}
+	if parts < 2 || !lower {
+		return 0
+	}
+	return n
+}
+
+//urlSchemesNotItalic are the well known schemes after which a // is part of a url, even when their urls aren't linked
+var urlSchemesNotItalic = []string{"http", "https", "ftp"}
+
+//isAfterURLScheme checks if the current position directly follows a well known or free link scheme, like the ftp: in ftp://example.com.
+// The // in a url isn't the start of italics, following any other scheme, as in foo://bar, it is.
+func (l *lexer) isAfterURLScheme() bool {
+	if l.pos == 0 || l.input[l.pos-1] != ':' {
+		return false
+	}
+	for _, schemes := range [][]string{urlSchemesNotItalic, l.freeLinkSchemes()} {
+		for _, scheme := range schemes {
+			start := l.pos - len(scheme) - 1
+			if start >= 0 && strings.EqualFold(l.input[start:l.pos-1], scheme) {
+				if start == 0 {
+					return true
+				}
+				r, _ := utf8.DecodeLastRuneInString(l.input[:start])
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			}
+		}
+	}
+	return false
+}
+
+//isPlaceholderStart checks if the << at the current position is closed by a >> on the same line.
+//The end of the line and the next >> are remembered, so a line of many << is only searched once.
+func (l *lexer) isPlaceholderStart() bool {
+	if l.pos >= l.lineEnd || l.pos < l.searchedFrom {
+		l.lineEnd = len(l.input)
+		if i := strings.IndexAny(l.input[l.pos:], "\n\r"); i >= 0 {
+			l.lineEnd = l.pos + i
+		}
+		l.closeAt = -1
+	}
+	if l.closeAt < l.pos {
+		l.closeAt = l.lineEnd
+		if i := strings.Index(l.input[l.pos:l.lineEnd], placeholderDelimRightToken); i >= 0 {
+			l.closeAt = l.pos + i
+		}
+	}
+	l.searchedFrom = l.pos
+	return l.closeAt < l.lineEnd
 }
 
 func isExplicitCloseMultiline(input string, currentPos int, closeDelim string) bool {
@@ -624,7 +1046,7 @@ func lexEmphasis(l *lexer) stateFn {
 
 func (l *lexer) emit(t itemType) {
 	//	fmt.Println("emitting", t, l.start, l.pos)
-	l.items <- item{t, l.start, l.input[l.start:l.pos]}
+	l.items = append(l.items, item{t, l.start, l.input[l.start:l.pos]})
 	l.start = l.pos
 	l.lastLastType = l.lastType
 	l.lastType = t
@@ -661,6 +1083,21 @@ func (l *lexer) incrementBreaksSinceList() {
 	}
 }
 
+// isLetter reports whether c is an ascii letter.
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+//isSchemeChar checks if c can be part of a url scheme, a letter followed by letters, digits, +, - and . as in svn+ssh
+func isSchemeChar(c byte) bool {
+	return isAlphaNumeric(c) || c == '+' || c == '-' || c == '.'
+}
+
+// isAlphaNumeric reports whether c is an ascii letter or digit.
+func isAlphaNumeric(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
 func isPound(r rune) bool {
 	return string(r) == "#"
 }
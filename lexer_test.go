@@ -2,6 +2,8 @@ package cajun
 
 import (
 	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -195,6 +197,41 @@ var lexTests = []lexTest{
 		{itemText, 0, ", -world"},
 		tEOF,
 	}},
+	{"mixed list", "* a\n*# b\n* c", []item{
+		{itemListUnorderedIncrease, 0, "*"},
+		{itemListUnordered, 0, ""},
+		{itemText, 0, " a"},
+		tNewLine,
+		{itemListOrderedIncrease, 0, "*#"},
+		{itemListOrdered, 0, ""},
+		{itemText, 0, " b"},
+		tNewLine,
+		{itemListUnorderedDecrease, 0, "*"},
+		{itemText, 0, " c"},
+		tEOF,
+	}},
+	{"table", " |=a||b|\n", []item{
+		{itemText, 0, " "},
+		{itemTableRowStart, 0, "|"},
+		{itemTableHeaderItem, 0, "="},
+		{itemText, 0, "a"},
+		{itemTableItem, 0, "|"},
+		{itemTableItem, 0, "|"},
+		{itemText, 0, "b"},
+		{itemTableRowEnd, 0, "|"},
+		tNewLine,
+		tEOF,
+	}},
+	{"free link at the end", "a https://b.com/c", []item{
+		{itemText, 0, "a "},
+		{itemFreeLink, 0, "https://b.com/c"},
+		tEOF,
+	}},
+	{"escaped free link at the end", "~https://b.com", []item{
+		{itemEscape, 0, "~"},
+		{itemEscapeText, 0, "https://b.com"},
+		tEOF,
+	}},
 	{"text with link", "hello- [[http://www.blah.com/whatever?asdf]], -world", []item{
 		{itemText, 0, "hello- "},
 		{itemLink, 0, "[[http://www.blah.com/whatever?asdf]]"},
@@ -259,11 +296,174 @@ var lexTests = []lexTest{
 		{itemText, 0, " -world"},
 		tEOF,
 	}},
+	{"block no wiki", "{{{\n a }}}\n }}}\n}}}\nb", []item{
+		{itemNoWikiBlockOpen, 0, "{{{"},
+		{itemNoWikiText, 0, "\n a }}}\n }}}\n"},
+		{itemNoWikiBlockClose, 0, "}}}"},
+		tNewLine,
+		{itemText, 0, "b"},
+		tEOF,
+	}},
+	{"block no wiki with a language", "{{{#!go \na\n}}}", []item{
+		{itemNoWikiBlockOpen, 0, "{{{#!go"},
+		{itemNoWikiText, 0, " \na\n"},
+		{itemNoWikiBlockClose, 0, "}}}"},
+		tEOF,
+	}},
+	{"no wiki trailing braces", "{{{a}}}}}", []item{
+		{itemNoWikiOpen, 0, "{{{"},
+		{itemNoWikiText, 0, "a}}"},
+		{itemNoWikiClose, 0, "}}}"},
+		tEOF,
+	}},
+	{"unclosed no wiki", "hello- {{{ test", []item{
+		{itemText, 0, "hello- "},
+		{itemError, 0, "unclosed nowiki, expected }}}"},
+	}},
+	{"unclosed link", "hello- [[test\n]]", []item{
+		{itemText, 0, "hello- "},
+		{itemError, 0, "unclosed link, expected ]] before the end of the line"},
+	}},
+	{"unclosed image", "{{test", []item{
+		{itemError, 0, "unclosed image, expected }} before the end of the line"},
+	}},
+	{"placeholder", "a <<toc>> b", []item{
+		{itemText, 0, "a "},
+		{itemPlaceholder, 0, "<<toc>>"},
+		{itemText, 0, " b"},
+		tEOF,
+	}},
+	{"block placeholder", "<<box x>>\nbody\n <</box>>\n", []item{
+		{itemPlaceholderBlockOpen, 0, "<<box x>>"},
+		{itemPlaceholderBlockBody, 0, "\nbody\n"},
+		{itemPlaceholderBlockClose, 0, " <</box>>"},
+		tNewLine,
+		tEOF,
+	}},
+	{"placeholder unclosed on the line", "a << b\n>>", []item{
+		{itemText, 0, "a << b"},
+		tNewLine,
+		{itemText, 0, ">>"},
+		tEOF,
+	}},
+}
+
+var lexAdditionsTests = []lexTest{
+	{"superscript", "a^^2^^", []item{
+		{itemText, 0, "a"},
+		{itemSuperscript, 0, "^^"},
+		{itemText, 0, "2"},
+		{itemSuperscript, 0, "^^"},
+		tEOF,
+	}},
+	{"subscript and underline", ",,a,, __b__", []item{
+		{itemSubscript, 0, ",,"},
+		{itemText, 0, "a"},
+		{itemSubscript, 0, ",,"},
+		{itemText, 0, " "},
+		{itemUnderline, 0, "__"},
+		{itemText, 0, "b"},
+		{itemUnderline, 0, "__"},
+		tEOF,
+	}},
+	{"monospace", "##a##", []item{
+		{itemMonospace, 0, "##"},
+		{itemText, 0, "a"},
+		{itemMonospace, 0, "##"},
+		tEOF,
+	}},
+	{"ordered list not monospace", "# a\n## b", []item{
+		{itemListOrderedIncrease, 0, "#"},
+		{itemListOrdered, 0, ""},
+		{itemText, 0, " a"},
+		tNewLine,
+		{itemListOrderedIncrease, 0, "##"},
+		{itemListOrdered, 0, ""},
+		{itemText, 0, " b"},
+		tEOF,
+	}},
+	{"escaped", "~^^a", []item{
+		{itemEscape, 0, "~"},
+		{itemEscapeText, 0, "^"},
+		{itemText, 0, "^a"},
+		tEOF,
+	}},
+}
+
+func TestLexWikiWords(t *testing.T) {
+	l := lex("wiki words", "a FrontPage ~WikiWord")
+	l.extensions = ExtensionWikiWords
+	expected := []item{
+		{itemText, 0, "a "},
+		{itemWikiWord, 0, "FrontPage"},
+		{itemText, 0, " "},
+		{itemEscape, 0, "~"},
+		{itemEscapeText, 0, "WikiWord"},
+		tEOF,
+	}
+	items := drain(l)
+	if !equal(items, expected, false) {
+		t.Errorf("got\n\t%+v\nexpected\n\t%v", items, expected)
+	}
+}
+
+func TestLexDefinitionLists(t *testing.T) {
+	l := lex("definition lists", "; a : b\n: c:d")
+	l.extensions = ExtensionDefinitionLists
+	expected := []item{
+		{itemDefinitionTerm, 0, ";"},
+		{itemText, 0, " a "},
+		{itemDefinition, 0, ":"},
+		{itemText, 0, " b"},
+		tNewLine,
+		{itemDefinition, 0, ":"},
+		{itemText, 0, " c:d"},
+		tEOF,
+	}
+	items := drain(l)
+	if !equal(items, expected, false) {
+		t.Errorf("got\n\t%+v\nexpected\n\t%v", items, expected)
+	}
+}
+
+func TestLexIndentAndBlockquotes(t *testing.T) {
+	l := lex("indent and blockquotes", ":: a\n> b > c\n>>d")
+	l.extensions = ExtensionIndent | ExtensionBlockquotes
+	expected := []item{
+		{itemIndent, 0, "::"},
+		{itemText, 0, " a"},
+		tNewLine,
+		{itemBlockquote, 0, ">"},
+		{itemText, 0, " b > c"},
+		tNewLine,
+		{itemBlockquote, 0, ">>"},
+		{itemText, 0, "d"},
+		tEOF,
+	}
+	items := drain(l)
+	if !equal(items, expected, false) {
+		t.Errorf("got\n\t%+v\nexpected\n\t%v", items, expected)
+	}
+}
+
+func TestLexAdditions(t *testing.T) {
+	for _, test := range lexAdditionsTests {
+		l := lex(test.name, test.input)
+		l.extensions = ExtensionAdditions
+		items := drain(l)
+		if !equal(items, test.items, false) {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%v", test.name, items, test.items)
+		}
+	}
 }
 
 // collect gathers the emitted items into a slice.
 func collect(t *lexTest, left, right string) (items []item) {
-	l := lex(t.name, t.input)
+	return drain(lex(t.name, t.input))
+}
+
+// drain gathers the items of l up to and including the EOF or error.
+func drain(l *lexer) (items []item) {
 	for {
 		item := l.nextItem()
 		items = append(items, item)
@@ -376,3 +576,39 @@ func TestLex(t *testing.T) {
 //		}
 //	}
 //}
+
+// benchmarkLex lexes input b.N times, reporting the throughput
+func benchmarkLex(b *testing.B, input string) {
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := lex("bench", input)
+		for {
+			item := l.nextItem()
+			if item.typ == itemEOF || item.typ == itemError {
+				break
+			}
+		}
+	}
+}
+
+// benchmarkInput returns creole1.0test.txt, repeated until it is at least size bytes long
+func benchmarkInput(b *testing.B, size int) string {
+	dat, err := ioutil.ReadFile("creole1.0test.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return strings.Repeat(string(dat), (size+len(dat)-1)/len(dat))
+}
+
+func BenchmarkLexCreoleTest(b *testing.B) {
+	benchmarkLex(b, benchmarkInput(b, 1))
+}
+
+func BenchmarkLex1MB(b *testing.B) {
+	benchmarkLex(b, benchmarkInput(b, 1<<20))
+}
+
+func BenchmarkLexUnclosedPlaceholders(b *testing.B) {
+	benchmarkLex(b, strings.Repeat("<<", 40000)+"\n>>")
+}
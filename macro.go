@@ -0,0 +1,122 @@
+package cajun
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MacroContext describes where a macro is being expanded.
+type MacroContext struct {
+	Name    string  // the name of the macro, as written
+	Pos     Pos     // byte offset of the << in the input
+	Block   bool    // the placeholder is on a line of its own, rather than within a paragraph
+	Options Options // the options the document is being parsed with
+}
+
+// MacroFunc expands a <<name args>> placeholder into a node.
+// body is the text between <<name args>> and <</name>> when the block form is used, otherwise it is empty.
+// A nil node removes the placeholder, an error leaves it as text and is reported through Options.Warn.
+// An inline node, e.g. Text or HTML, returned for the block form is put in a paragraph of its own.
+type MacroFunc func(ctx *MacroContext, args MacroArgs, body string) (Node, error)
+
+var (
+	macrosMu sync.RWMutex
+	macros   = make(map[string]MacroFunc)
+)
+
+// RegisterMacro makes fn expand the placeholders called name, e.g. <<date>> for "date".
+// Names are case insensitive. Registering nil removes the macro.
+// It is safe to call concurrently with parsing.
+func RegisterMacro(name string, fn MacroFunc) {
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	if fn == nil {
+		delete(macros, strings.ToLower(name))
+	} else {
+		macros[strings.ToLower(name)] = fn
+	}
+}
+
+func init() {
+	RegisterMacro("code", codeMacro)
+}
+
+// codeMacro expands <<code lang=go>> ... <</code>> into a nowiki block of code in the language, the same as {{{#!go
+func codeMacro(ctx *MacroContext, args MacroArgs, body string) (Node, error) {
+	if !ctx.Block {
+		return nil, fmt.Errorf("the code goes on the lines between <<code>> and <</code>>")
+	}
+	n := newNoWiki(ctx.Pos)
+	n.Text = body
+	n.Block = true
+	if lang, ok := args.Get("lang"); ok {
+		if !isLang(lang) {
+			return nil, fmt.Errorf("%q is not a language", lang)
+		}
+		n.Lang = lang
+	}
+	return n, nil
+}
+
+// lookupMacro returns the macro registered for name, if any
+func lookupMacro(name string) MacroFunc {
+	macrosMu.RLock()
+	defer macrosMu.RUnlock()
+	return macros[strings.ToLower(name)]
+}
+
+// MacroArgs are the whitespace separated arguments of a placeholder, e.g. the page=Intro of <<include page=Intro>>.
+// An argument in double quotes can contain spaces, the quotes are removed.
+type MacroArgs []string
+
+// Get returns the value of the key=value argument called key.
+func (a MacroArgs) Get(key string) (string, bool) {
+	for _, arg := range a {
+		if i := strings.Index(arg, "="); i >= 0 && arg[:i] == key {
+			return arg[i+1:], true
+		}
+	}
+	return "", false
+}
+
+// parsePlaceholder splits <<name args>> into the name and the rest.
+// The name is empty for <<>> and for the <</name>> closing a block.
+func parsePlaceholder(val string) (name string, args string) {
+	inner := strings.TrimSuffix(strings.TrimPrefix(val, placeholderDelimLeftToken), placeholderDelimRightToken)
+	inner = strings.TrimSpace(inner)
+	if strings.HasPrefix(inner, "/") {
+		return "", ""
+	}
+	if i := strings.IndexAny(inner, " \t"); i >= 0 {
+		return inner[:i], strings.TrimSpace(inner[i:])
+	}
+	return inner, ""
+}
+
+// parseMacroArgs splits raw at whitespace outside of double quotes
+func parseMacroArgs(raw string) MacroArgs {
+	var args MacroArgs
+	var arg strings.Builder
+	quoted, started := false, false
+	for _, r := range raw {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if started {
+				args = append(args, arg.String())
+				arg.Reset()
+				started = false
+			}
+		default:
+			arg.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -0,0 +1,89 @@
+package cajun
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterMacro("date", func(ctx *MacroContext, args MacroArgs, body string) (Node, error) {
+		return NewText("2026-10-17"), nil
+	})
+	RegisterMacro("greet", func(ctx *MacroContext, args MacroArgs, body string) (Node, error) {
+		name, _ := args.Get("name")
+		return NewHTML(fmt.Sprintf("<b>hi %s</b> %v %v", name, args, ctx.Block)), nil
+	})
+	RegisterMacro("box", func(ctx *MacroContext, args MacroArgs, body string) (Node, error) {
+		return ParseWithOptions(body, ctx.Options)
+	})
+	RegisterMacro("fail", func(ctx *MacroContext, args MacroArgs, body string) (Node, error) {
+		return nil, fmt.Errorf("no %s", strings.Join(args, ","))
+	})
+	RegisterMacro("nothing", func(ctx *MacroContext, args MacroArgs, body string) (Node, error) {
+		return nil, nil
+	})
+}
+
+var macroTests = []parserTest{
+	{"inline", "Today is <<date>>.", "<p>Today is 2026-10-17.</p>"},
+	{"case insensitive", "<<DATE>> x", "<p>2026-10-17 x</p>"},
+	{"after a newline", "Today is\n<<date>> ok", "<p>Today is\n2026-10-17 ok</p>"},
+	{"args", "a <<greet name=\"Ada Lovelace\" loud>>", "<p>a <b>hi Ada Lovelace</b> [name=Ada Lovelace loud] false</p>"},
+	{"block", "text\n<<greet name=x>>\nmore", "<p>text</p><p><b>hi x</b> [name=x] true</p><p>more</p>"},
+	{"block of inline text", "<<date>>\nmore", "<p>2026-10-17</p><p>more</p>"},
+	{"block with body", "<<box>>\n**a**\n\nb\n<</box>>\n\nafter", "<p><strong>a</strong></p><p>b</p><p>after</p>"},
+	{"unclosed body", "<<box>>\n**a**", "<p><strong>a</strong></p>"},
+	{"unknown", "<<unknown x>>", "<p>&lt;&lt;unknown x&gt;&gt;</p>"},
+	{"unknown block", "<<unknown>>\nbody\n<</unknown>>", "<p>&lt;&lt;unknown&gt;&gt;\nbody\n&lt;&lt;/unknown&gt;&gt;</p>"},
+	{"unknown block with markup", "<<unknown>>\n**a**\n\nb\n<</unknown>>", "<p>&lt;&lt;unknown&gt;&gt;\n<strong>a</strong></p><p>b\n&lt;&lt;/unknown&gt;&gt;</p>"},
+	{"removed", "a <<nothing>> b", "<p>a  b</p>"},
+	{"close without open", "<</box>>", "<p>&lt;&lt;/box&gt;&gt;</p>"},
+	{"code", "<<code lang=go>>\nif a < b {\n\n}\n<</code>>", "<pre><code class=\"language-go\">if a &lt; b {\n\n}</code></pre>"},
+	{"code without a language", "<<code>>\n**a**\n<</code>>", "<pre>**a**</pre>"},
+	{"inline code", "a <<code lang=go>> b", "<p>a &lt;&lt;code lang=go&gt;&gt; b</p>"},
+	{"code with a bad language", "<<code lang=\"a b\">>\nc\n<</code>>", "<p>&lt;&lt;code lang=&#34;a b&#34;&gt;&gt;\nc\n&lt;&lt;/code&gt;&gt;</p>"},
+	{"in nowiki", "{{{<<date>>}}}", "<p><tt>&lt;&lt;date&gt;&gt;</tt></p>"},
+}
+
+func TestMacros(t *testing.T) {
+	for _, test := range macroTests {
+		output, err := Transform(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.output != output {
+			t.Errorf("%s: got\n\t%q\nexpected\n\t%q", test.name, output, test.output)
+		}
+	}
+}
+
+func TestMacroError(t *testing.T) {
+	var warnings []Warning
+	output, err := TransformWithOptions("x <<fail a b>>", Options{Warn: func(w Warning) { warnings = append(warnings, w) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "<p>x &lt;&lt;fail a b&gt;&gt;</p>" {
+		t.Errorf("unexpected output %q", output)
+	}
+	expected := []Warning{{Pos: 2, Msg: "macro \"fail\" failed: no a,b"}}
+	if fmt.Sprint(warnings) != fmt.Sprint(expected) {
+		t.Errorf("got\n\t%v\nexpected\n\t%v", warnings, expected)
+	}
+}
+
+func TestTokenPlaceholder(t *testing.T) {
+	for token := range Tokens("<<include page=\"Front Page\" raw>>") {
+		name, args, ok := token.Placeholder()
+		if !ok || name != "include" || fmt.Sprint(args) != "[page=Front Page raw]" {
+			t.Errorf("got %q %q %v", name, args, ok)
+		}
+		if page, _ := args.Get("page"); page != "Front Page" {
+			t.Errorf("got page %q", page)
+		}
+	}
+	if _, _, ok := (Token{Type: TokenText, Val: "<<a>>"}).Placeholder(); ok {
+		t.Errorf("text isn't a placeholder")
+	}
+}
@@ -0,0 +1,108 @@
+package cajun
+
+// Extension is a set of flags enabling syntax that isn't part of Creole 1.0.
+type Extension int
+
+const (
+	// ExtensionAdditions enables the inline markup of the WikiCreole additions,
+	// ^^superscript^^, ,,subscript,,, ##monospace## and __underline__.
+	ExtensionAdditions Extension = 1 << iota
+
+	// ExtensionWikiWords links WikiWords, capitalised words run together such as FrontPage, as if they were [[FrontPage]].
+	// A ~ before a WikiWord stops it being linked.
+	ExtensionWikiWords
+
+	// ExtensionTableCells allows markers at the start of a table cell setting its colspan and alignment,
+	// e.g. |<2>Total spanning two columns, |= >Total aligned to the right, |<left and |:centered:|.
+	ExtensionTableCells
+
+	// ExtensionDefinitionLists enables the definition lists of the WikiCreole additions,
+	// a ; term at the start of a line followed by its : definitions, on the same line after whitespace or on lines of their own.
+	ExtensionDefinitionLists
+
+	// ExtensionIndent indents the lines starting with :, one level for each :, e.g. ::deeper, as in the WikiCreole additions.
+	// With ExtensionDefinitionLists a line starting with a single : following a term is its definition instead.
+	ExtensionIndent
+
+	// ExtensionBlockquotes quotes the lines starting with >, one level for each >, e.g. >> a quote in a quote, as in emails.
+	ExtensionBlockquotes
+)
+
+// Options configures how creole is transformed.
+// The zero value transforms creole to html the same way Transform does.
+type Options struct {
+	// Renderer writes the output, when nil an HTMLRenderer configured by these options is used.
+	// The remaining fields, other than Extensions, only configure that HTMLRenderer.
+	Renderer Renderer
+
+	// Unsafe turns off safe mode, allowing links and images to use any url scheme.
+	// Only use it for trusted input, text is always escaped either way.
+	Unsafe bool
+
+	// AllowedSchemes lists the url schemes links and images may use in safe mode, when nil DefaultAllowedSchemes is used.
+	AllowedSchemes []string
+
+	// FreeLinkSchemes lists the url schemes of the urls in the text that are made into links, e.g. "https" for https://example.com.
+	// When nil DefaultFreeLinkSchemes is used. Links to schemes that aren't allowed are still written as text.
+	// A // following these schemes, or http, https and ftp, is part of a url even when it isn't linked.
+	// Following any other scheme, as in foo://bar, it is italics.
+	FreeLinkSchemes []string
+
+	// LinkResolver, when set, maps internal links, i.e. [[Page Name]], to the href in the output.
+	LinkResolver LinkResolver
+
+	// MissingLinkClass is the css class of internal links to pages that don't exist, when empty DefaultMissingLinkClass is used.
+	MissingLinkClass string
+
+	// ImageResolver, when set, maps the location of each image to the src, and size, in the output.
+	ImageResolver ImageResolver
+
+	// Warn, when set, is called with each problem that doesn't stop the transform, e.g. a rejected image.
+	Warn func(Warning)
+
+	// HeadingIDs gives each heading an id attribute made from its text by Slug.
+	// Repeated ids are made unique by adding -1, -2, ..., e.g. intro, intro-1.
+	HeadingIDs bool
+
+	// HeadingID, when set, is used instead of Slug to make heading ids, it implies HeadingIDs.
+	HeadingID func(text string) string
+
+	// TOC replaces each <<toc>> with a table of contents, a nested list linking to the headings.
+	// It implies HeadingIDs. As the whole document is needed, TransformTo doesn't stream the output when it is set.
+	TOC bool
+
+	// HeadingPermalinks adds a link to itself, a permalink, at the end of each heading that has an id.
+	HeadingPermalinks bool
+
+	// Highlighter, when set, highlights the code of nowiki blocks tagged with a language, e.g. {{{#!go.
+	Highlighter Highlighter
+
+	// HardWrap renders each newline within a paragraph as a line break, rather than joining the lines.
+	HardWrap bool
+
+	// Extensions enables syntax beyond Creole 1.0.
+	Extensions Extension
+}
+
+// NewHTMLRenderer returns an HTMLRenderer configured by opts.
+func NewHTMLRenderer(opts Options) *HTMLRenderer {
+	return &HTMLRenderer{
+		Unsafe:           opts.Unsafe,
+		AllowedSchemes:   opts.AllowedSchemes,
+		LinkResolver:     opts.LinkResolver,
+		MissingLinkClass: opts.MissingLinkClass,
+		ImageResolver:    opts.ImageResolver,
+		Warn:             opts.Warn,
+		Permalinks:       opts.HeadingPermalinks,
+		HardWrap:         opts.HardWrap,
+		Highlighter:      opts.Highlighter,
+	}
+}
+
+// renderer returns the configured Renderer or the default html one
+func (o Options) renderer() Renderer {
+	if o.Renderer != nil {
+		return o.Renderer
+	}
+	return NewHTMLRenderer(o)
+}
@@ -3,59 +3,39 @@ package cajun
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
-// itemTokens is a map containing the start and ending html tags required to process creole
-var itemTokens = map[itemType][]string{
-	itemBold:                    []string{"<strong>", "</strong>"},
-	itemEOF:                     []string{"</br>", ""},
-	itemFreeLink:                []string{"<a href=\"{{val}}\">{{val}}</a>", ""},
-	itemHeading1:                []string{"<h1>", "</h1>"},
-	itemHeading2:                []string{"<h2>", "</h2>"},
-	itemHeading3:                []string{"<h3>", "</h3>"},
-	itemHeading4:                []string{"<h4>", "</h4>"},
-	itemHeading5:                []string{"<h5>", "</h5>"},
-	itemHeading6:                []string{"<h6>", "</h6>"},
-	itemHorizontalRule:          []string{"<hr>", ""},
-	itemImage:                   []string{"<img src=\"{{location}}\" alt=\"{{text}}\" />", ""},
-	itemImageLeftDelimiter:      []string{"<img", ""},
-	itemImageLocation:           []string{"src=\"", "\""},
-	itemImageText:               []string{"alt=\"", "\""},
-	itemImageRightDelimiter:     []string{"/>", ""},
-	itemItalics:                 []string{"<em>", "</em>"},
-	itemLink:                    []string{"<a href=\"{{location}}\">{{text}}</a>", ""},
-	itemLineBreak:               []string{"<br />", ""},
-	itemListUnordered:           []string{"<li>", "</li>"},
-	itemListUnorderedIncrease:   []string{"<ul>", "</ul>"},
-	itemListUnorderedSameAsLast: []string{"<li>", "</li>"},
-	itemListUnorderedDecrease:   []string{"<li>", "</li>"},
-	itemListOrdered:             []string{"<li>", "</li>"},
-	itemListOrderedIncrease:     []string{"<ol>", "</ol>"},
-	itemListOrderedSameAsLast:   []string{"<li>", "</li>"},
-	itemListOrderedDecrease:     []string{"<li>", "</li>"},
-	itemTable:                   []string{"<table>", "</table>"},
-	itemTableRow:                []string{"<tr>", "</tr>"},
-	itemTableHeaderItem:         []string{"<th>", "</th>"},
-	itemText:                    []string{"<p>", "</p>"},
-	itemTableItem:               []string{"<td>", "</td>"},
-	itemNewLine:                 []string{"</br>", ""},
-	//itemParagraph:       []string{"<p>", "</p>"},
-	itemSpaceRun:      []string{"", ""},
-	itemNoWiki:        []string{"<pre>", "</pre>"},
-	itemWikiLineBreak: []string{"</br>", ""},
-}
-
 //parser keeps track of input processing
 type parser struct {
 	name           string
 	input          string
+	offset         int              // byte offset of input in the whole document
 	openList       map[itemType]int //maybe an int instead of bool, to count the open items ++/--
 	preClosedList  map[itemType]int //maybe an int instead of bool, to count the open items ++/--
 	openItemsStack *openItems
-	items          []item
+	newLines       int  // newlines since the last item that wasn't whitespace
+	blank          bool // nothing but whitespace has been seen since the start of the input
 	lex            *lexer
 	depth          int
+	doc            *Document
+	noWiki         *NoWiki // the nowiki currently being filled, if any
+	headRow        *Row    // the first row of the open table, it is the head of the table while all its cells are headers
+	cell           *Cell   // the last table cell opened, until it is finished by closeCell
+	softBreak      bool    // a newline was seen, which becomes a SoftBreak if inline content follows in the same block
+	lineStart      bool    // nothing but whitespace has been seen since the last newline
+	opts           Options
+	macro          item   // the block placeholder whose body is being read, if any
+	macroBody      string // the body of the block placeholder
+}
+
+//appender is a node that children can be added to while parsing
+type appender interface {
+	Node
+	appendChild(Node)
+	mergeText()
 }
 
 //isOpen checks if this item is in the openList
@@ -76,6 +56,33 @@ func (p *parser) wasPreClosed(typ itemType) bool {
 	}
 }
 
+//current returns the innermost open node, which is where new nodes are added
+func (p *parser) current() appender {
+	if p.openItemsStack.Len() > 0 {
+		return p.openItemsStack.top.node
+	}
+	return p.doc
+}
+
+//add appends n to the innermost open node
+func (p *parser) add(n Node) {
+	if p.softBreak {
+		p.softBreak = false
+		switch n.(type) {
+		case *Text, *Link, *Image, *Bold, *Italics, *NoWiki, *LineBreak, *Superscript, *Subscript, *Monospace, *Underline:
+			p.current().appendChild(newSoftBreak(n.Position()))
+		}
+	}
+	p.current().appendChild(n)
+}
+
+//open appends n to the innermost open node and makes it the innermost open node
+func (p *parser) open(typ itemType, n appender) {
+	p.add(n)
+	p.openItemsStack.Push(typ, n)
+	p.openList[typ]++
+}
+
 // -> **// test **// and **// test2 **//
 //   -> isOpen(strong). no. add to open items list.
 //	 -> isOpen(italics). no. add to open items list.
@@ -83,91 +90,258 @@ func (p *parser) wasPreClosed(typ itemType) bool {
 //	 -> isOpen(italics). yes. close all. closing first pop. strong. add to preclose.  closing second pop. italics.
 //	 -> isOpen(strong) no. but should not write open tag either.
 
-//closeOthers returns all closing tags up to the intended close target itemType
-func (p *parser) closeOthers(typ itemType) string {
-	var buffer bytes.Buffer
-	//var found = false
-
-	//var addMeBack = make(map[itemType]int)
+//closeOthers closes all open items up to and including the intended close target itemType
+func (p *parser) closeOthers(typ itemType) {
 	for p.openItemsStack.Len() > 0 {
-		t := p.openItemsStack.Pop()
-		if val, ok := itemTokens[t]; ok {
-			buffer.WriteString(val[1])
-			p.openList[t]--
-			if t == typ {
-				//				found = true
-				break
-			} else {
-				//addMeBack[t]++
-				// closed early
-				p.preClosedList[t]++
-			}
+		t, n := p.openItemsStack.Pop()
+		p.openList[t]--
+		n.mergeText()
+		if t == typ {
+			break
 		}
+		// closed early
+		p.preClosedList[t]++
 	}
-	//if we didn't find anything then we don't want to go closing everything
-	//	if !found {
-	//		for k, _ := range addMeBack {
-	//			//a map isn't ordered, this could cause trouble.
-	//			p.openItemsStack.Push(k) //deal with multiple of same type? if yes, need to change away from map, as we'll need to maintain order
-	//		}
-	//	}
-	return buffer.String()
 }
 
-// closeSpecific will only close the target itemType and will only search back according to limit
-func (p *parser) closeSpecific(typ itemType, limit int) string {
-	var buffer bytes.Buffer
-	var limitCount = 0
-	var addBacks openItems
+//pop closes the innermost open item, it isn't marked as closed early, e.g. at the end of a list item or table cell
+func (p *parser) pop() itemType {
+	t, n := p.openItemsStack.Pop()
+	p.openList[t]--
+	n.mergeText()
+	return t
+}
+
+//peek returns the type of the innermost open item, itemUnset if nothing is open
+func (p *parser) peek() itemType {
+	t, _ := p.openItemsStack.Peek()
+	return t
+}
+
+//closeAll will close everything that is open, e.g. at a double line break ending a paragraph
+func (p *parser) closeAll() {
 	for p.openItemsStack.Len() > 0 {
-		if limitCount == limit {
-			break
+		p.pop()
+	}
+	p.doc.mergeText()
+	//anything closed early was closed in the block that just ended, it shouldn't affect the next one
+	if len(p.preClosedList) > 0 {
+		p.preClosedList = make(map[itemType]int)
+	}
+	p.softBreak = false
+}
+
+//closeTo closes everything opened inside of the innermost open typ
+func (p *parser) closeTo(typ itemType) {
+	for p.openItemsStack.Len() > 0 && p.peek() != typ {
+		p.pop()
+	}
+}
+
+//toggle opens inline formatting, like bold, or closes it when it is already open.
+//A delimiter closing formatting that was closed early, by closing the formatting around it, is ignored.
+func (p *parser) toggle(typ itemType, pos Pos, node func() appender) {
+	if p.wasPreClosed(typ) {
+		//ignore this item one time
+		p.preClosedList[typ]--
+	} else if !p.isOpen(typ) {
+		p.openInline(pos)
+		p.open(typ, node())
+	} else {
+		p.closeOthers(typ)
+	}
+}
+
+//openInline makes sure inline content, like text or links, has somewhere to go, starting a paragraph if needed
+func (p *parser) openInline(pos Pos) {
+	if isInlineContainer(p.peek()) {
+		return
+	}
+	p.closeAll()
+	p.open(itemText, newParagraph(pos))
+}
+
+//text adds text, starting a paragraph if needed.
+//Whitespace on its own is dropped at the start of a line or outside of a paragraph, heading, list item or cell.
+func (p *parser) text(pos Pos, val string, lineStart bool) {
+	if strings.TrimSpace(val) == "" && (lineStart || !isInlineContainer(p.peek())) {
+		p.lineStart = lineStart
+		return
+	}
+	p.openInline(pos)
+	p.add(newText(pos, val))
+}
+
+//isHeadingOpen checks if a heading of any level is open
+func (p *parser) isHeadingOpen() bool {
+	for t := itemHeading1; t <= itemHeading6; t++ {
+		if p.isOpen(t) {
+			return true
 		}
-		t := p.openItemsStack.Pop()
-		if val, ok := itemTokens[t]; ok {
-			if t == typ {
-				buffer.WriteString(val[1])
-				limitCount++
-				p.openList[t]--
-			} else {
-				addBacks.Push(t)
-				// closed early
-				p.preClosedList[t]++
+	}
+	return false
+}
+
+//listDepth returns the number of nested lists that are open
+func (p *parser) listDepth() int {
+	return p.openList[itemListUnorderedIncrease] + p.openList[itemListOrderedIncrease]
+}
+
+//openListItem starts a list item as deep as its marker, e.g. *# or ##, closing and opening lists to get there.
+//The list at that depth is replaced when it is of the other kind, i.e. ordered rather than unordered.
+//A marker mixing * and # gives the kind of every level, the lists it is nested in are replaced too when they are of the other kind.
+func (p *parser) openListItem(pos Pos, marker string) {
+	depth := len(marker)
+	listTyp, itemTyp := listKind(marker[depth-1])
+	if p.listDepth() == 0 {
+		p.closeAll()
+	}
+	for p.listDepth() > depth {
+		p.pop()
+	}
+	mixed := strings.Trim(marker, marker[:1]) != ""
+	if mixed {
+		for level := p.mismatchedListLevel(marker); p.listDepth() > level; {
+			p.pop()
+		}
+	}
+	if p.listDepth() == depth {
+		//close the previous item at this depth, along with anything in it
+		for !isList(p.peek()) {
+			p.pop()
+		}
+		if p.peek() != listTyp {
+			p.pop()
+		}
+	}
+	for p.listDepth() < depth {
+		levelTyp := listTyp
+		if mixed {
+			levelTyp, _ = listKind(marker[p.listDepth()])
+		}
+		if p.listDepth() > 0 {
+			//a nested list goes in the current item
+			for !isListItem(p.peek()) && !isList(p.peek()) {
+				p.pop()
+			}
+			if isList(p.peek()) {
+				p.open(listItemType(p.peek()), newListItem(pos))
 			}
 		}
+		p.open(levelTyp, newList(pos, levelTyp == itemListOrderedIncrease))
+	}
+	p.open(itemTyp, newListItem(pos))
+}
+
+//openDefinition starts a definition of the last term in the open definition list
+func (p *parser) openDefinition(pos Pos) {
+	p.closeTo(itemDefinitionList)
+	p.open(itemDefinition, newDefinition(pos))
+}
 
+//openNested makes the line part of the indent or blockquote that is depth deep, closing and opening them to get there.
+//A line at the same depth as the last one continues it, joining the lines.
+func (p *parser) openNested(typ itemType, depth int, node func() appender) {
+	if !p.isOpen(typ) {
+		p.closeAll()
 	}
-	for addBacks.Len() > 0 {
-		val := addBacks.Pop()
-		p.openItemsStack.Push(val) //deal with multiple of same type? if yes, need to change away from map, as we'll need to maintain order
-		p.openList[val]++
+	for p.openList[typ] > depth {
+		p.pop()
 	}
+	for p.openList[typ] < depth {
+		//a nested one goes in the innermost, not in formatting inside it
+		if p.isOpen(typ) {
+			p.closeTo(typ)
+		}
+		p.open(typ, node())
+	}
+}
 
-	return buffer.String()
+//listKind returns the types of the list and its items for a * or # list marker
+func listKind(marker byte) (list itemType, item itemType) {
+	if marker == '#' {
+		return itemListOrderedIncrease, itemListOrdered
+	}
+	return itemListUnorderedIncrease, itemListUnordered
 }
 
-//closeAtDoubleLineBreak will close everything that is open
-func (p *parser) closeAtDoubleLineBreak() string {
-	var buffer bytes.Buffer
-	var addMeBack = make(map[itemType]int)
-	for p.openItemsStack.Len() > 0 {
-		t := p.openItemsStack.Pop()
-		//close everything when we encounter two line breaks. e.g. ending a paragraph
-		if val, ok := itemTokens[t]; ok {
-			buffer.WriteString(val[1])
-			p.openList[t]--
-			//do we care about preClosed? I think no.
-			//p.preClosedList[t]++ //Is this closed "early"?
+//listItemType returns the type of the items of a list type
+func listItemType(list itemType) itemType {
+	if list == itemListOrderedIncrease {
+		return itemListOrdered
+	}
+	return itemListUnordered
+}
+
+//mismatchedListLevel returns the level of the outermost open list that is of another kind than marker gives for its level.
+//Only the lists the new item is nested in are compared, not the one it is in.
+func (p *parser) mismatchedListLevel(marker string) int {
+	var lists []itemType
+	for o := p.openItemsStack.top; o != nil; o = o.next {
+		if isList(o.typ) {
+			lists = append([]itemType{o.typ}, lists...)
+		}
+	}
+	for level, typ := range lists {
+		if level >= len(marker)-1 {
+			break
 		}
+		if want, _ := listKind(marker[level]); typ != want {
+			return level
+		}
+	}
+	return len(lists)
+}
 
+//isInlineContainer checks if text and other inline content can be added to an open item of this type
+func isInlineContainer(typ itemType) bool {
+	switch typ {
+	case itemText, itemHeading1, itemHeading2, itemHeading3, itemHeading4, itemHeading5, itemHeading6,
+		itemListUnordered, itemListOrdered, itemTableItem, itemTableHeaderItem, itemBold, itemItalics,
+		itemSuperscript, itemSubscript, itemMonospace, itemUnderline, itemDefinitionTerm, itemDefinition,
+		itemIndent, itemBlockquote:
+		return true
 	}
-	for k, _ := range addMeBack {
-		//a map isn't ordered, this could cause trouble.
-		p.openItemsStack.Push(k) //deal with multiple of same type? if yes, need to change away from map, as we'll need to maintain order
+	return false
+}
+
+//isInline checks if n is inline content, which goes in a paragraph rather than directly in the document
+func isInline(n Node) bool {
+	switch n := n.(type) {
+	case *Text, *HTML, *Link, *Image, *Bold, *Italics, *LineBreak, *SoftBreak, *Superscript, *Subscript, *Monospace, *Underline:
+		return true
+	case *NoWiki:
+		return !n.Block
+	}
+	return false
+}
+
+func isList(typ itemType) bool {
+	return typ == itemListUnorderedIncrease || typ == itemListOrderedIncrease
+}
+
+func isListItem(typ itemType) bool {
+	return typ == itemListUnordered || typ == itemListOrdered
+}
 
+//trimBlockNoWiki drops the line breaks following the {{{ and preceding the }}} of a block nowiki
+func trimBlockNoWiki(text string) string {
+	if i := strings.Index(text, "\n"); i >= 0 && strings.TrimSpace(text[:i]) == "" {
+		text = text[i+1:]
 	}
+	text = strings.TrimSuffix(text, "\n")
+	return strings.TrimSuffix(text, "\r")
+}
 
-	return buffer.String()
+//unindentNoWikiClose removes a space from lines of a block nowiki that are an indented }}}, which is how a }}} line is written inside the block
+func unindentNoWikiClose(text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, " ") && strings.TrimSpace(line) == "}}}" {
+			lines[i] = line[1:]
+		}
+	}
+	return strings.Join(lines, "")
 }
 
 // collect gathers the emitted items into a slice.
@@ -183,355 +357,461 @@ func (p *parser) collect(input string) (items []item) {
 	return items
 }
 
-//Transform processes an input string of creole markdown and returns html or error
-func Transform(input string) (output string, terror error) {
-	p := parser{}
+// ParseError describes malformed creole, e.g. an unclosed nowiki, and where it is in the input.
+type ParseError struct {
+	Offset int    // byte offset in the input
+	Line   int    // line number, starting at 1
+	Column int    // column in characters, starting at 1
+	Msg    string // description of the problem
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("creole:%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+//newParseError builds a ParseError, working out the line and column of offset in input
+func newParseError(input string, offset int, msg string) *ParseError {
+	lineStart := strings.LastIndex(input[:offset], "\n") + 1
+	return &ParseError{
+		Offset: offset,
+		Line:   strings.Count(input[:offset], "\n") + 1,
+		Column: utf8.RuneCountInString(input[lineStart:offset]) + 1,
+		Msg:    msg,
+	}
+}
+
+//Parse processes an input string of creole markdown and returns the document tree.
+//If the input is malformed, the document parsed up to the problem is returned with a *ParseError.
+func Parse(input string) (*Document, error) {
+	return ParseWithOptions(input, Options{})
+}
+
+//ParseWithOptions is Parse, with the syntax extensions enabled in opts.
+func ParseWithOptions(input string, opts Options) (*Document, error) {
+	doc, err := parse(input, 0, opts)
+	newHeadingIDs(opts).assign(doc)
+	fillTOC(doc)
+	return doc, err
+}
+
+//parse parses input that starts at offset in a larger document, nodes are positioned in the larger document.
+//The offset of a returned *ParseError is still relative to input.
+func parse(input string, offset int, opts Options) (*Document, error) {
+	p := parser{offset: offset}
 	p.openList = make(map[itemType]int)
 	p.preClosedList = make(map[itemType]int)
 	p.input = input
-	var buffer bytes.Buffer
+	p.doc = newDocument()
 	p.lex = lex("creole", input)
-	p.items = p.items[:0]
+	p.lex.extensions = opts.Extensions
+	p.lex.schemes = opts.FreeLinkSchemes
+	p.opts = opts
+	p.blank = true
 	p.openItemsStack = new(openItems)
+	p.lineStart = true
 	//TODO: refactor this long switch
-Done:
 	for {
 		item := p.lex.nextItem()
-		p.items = append(p.items, item)
+		pos := Pos(p.offset + item.pos)
+		lineStart := p.lineStart
+		p.lineStart = false
 
-		if p.isFollowingDoubleLineBreak(item) {
-			buffer.WriteString(p.closeAtDoubleLineBreak())
+		if p.isFollowingDoubleLineBreak() {
+			p.closeAll()
 		}
+		p.trackLineBreaks(item)
 		switch item.typ {
 
 		case itemText:
-			if p.isParagraphStart(item) {
-
-				buffer.WriteString("<p>")
-				p.openItemsStack.Push(itemText)
-				p.openList[item.typ]++
-			}
-
-			buffer.WriteString(item.val)
+			p.text(pos, item.val, lineStart)
 			break
 		case itemBold:
 			//**//test**// should be <strong><em>test</em></strong>
-			if p.wasPreClosed(itemBold) {
-				//ignore this item one time
-				p.preClosedList[itemBold]--
-			} else {
-				if p.isOpen(itemBold) == false {
-					buffer.WriteString("<strong>")
-					p.openItemsStack.Push(itemBold)
-					p.openList[item.typ]++
-				} else {
-					buffer.WriteString(p.closeOthers(itemBold))
-				}
-			}
+			p.toggle(item.typ, pos, func() appender { return newBold(pos) })
 			break
 		case itemItalics:
-			if p.wasPreClosed(itemItalics) {
-				//ignore this item one time
-				p.preClosedList[itemItalics]--
-			} else {
-				if p.isOpen(itemItalics) == false {
-					buffer.WriteString("<em>")
-					p.openItemsStack.Push(itemItalics)
-					p.openList[item.typ]++
-				} else {
-					buffer.WriteString(p.closeOthers(itemItalics))
-				}
-			}
+			p.toggle(item.typ, pos, func() appender { return newItalics(pos) })
+			break
+		case itemSuperscript:
+			p.toggle(item.typ, pos, func() appender { return newSuperscript(pos) })
+			break
+		case itemSubscript:
+			p.toggle(item.typ, pos, func() appender { return newSubscript(pos) })
+			break
+		case itemMonospace:
+			p.toggle(item.typ, pos, func() appender { return newMonospace(pos) })
+			break
+		case itemUnderline:
+			p.toggle(item.typ, pos, func() appender { return newUnderline(pos) })
 			break
 		case itemHeading1, itemHeading2, itemHeading3, itemHeading4, itemHeading5, itemHeading6:
-			if p.wasPreClosed(item.typ) {
-				//ignore this item one time
-				p.preClosedList[item.typ]--
-			} else {
-				if p.isOpen(item.typ) == false {
-					if val, ok := itemTokens[item.typ]; ok {
-						buffer.WriteString(val[0])
-					} else {
-						fmt.Errorf("Can not find item token")
-					}
-					p.openItemsStack.Push(item.typ)
-					p.openList[item.typ]++
-				} else {
-					buffer.WriteString(p.closeOthers(item.typ))
-				}
-			}
+			//a heading is a block of its own, the closing = run is optional
+			p.closeAll()
+			p.open(item.typ, newHeading(pos, int(item.typ-itemHeading1)+1))
 			break
 		case itemHeadingCloseRun:
-			//TODO: fix this, it is messy
-			var closeTag = ""
-			closeTag += p.closeOthers(itemHeading1)
-			closeTag += p.closeOthers(itemHeading2)
-			closeTag += p.closeOthers(itemHeading3)
-			closeTag += p.closeOthers(itemHeading4)
-			closeTag += p.closeOthers(itemHeading5)
-			closeTag += p.closeOthers(itemHeading6)
-
-			if closeTag != "" {
-				if !strings.HasPrefix(closeTag, "</h") {
-					buffer.WriteString(item.val)
-				}
-				buffer.WriteString(closeTag)
+			if p.isHeadingOpen() {
+				p.closeAll()
 			} else {
-				//TODO: we aren't hitting this because something else could be open (e.g. a <p> tag or something)
-				// if close tag is empty or not a heading that was left open
-				//	fmt.Println(" NOTHING OPEN============ ", closeTag)
+				//the close run doesn't close a heading, so it is just text
+				p.text(pos, item.val, lineStart)
 			}
 			break
-		case itemListUnordered, itemListUnorderedIncrease, itemListUnorderedSameAsLast, itemListUnorderedDecrease:
-			var listLength = len(item.val)
-			if item.typ == itemListUnorderedSameAsLast {
-				var closed = false
-				closeSame := p.closeSpecific(itemListUnorderedSameAsLast, 1)
-				if closeSame != "" {
-					buffer.WriteString(closeSame)
-					closed = true
-				}
-				if !closed {
-					closeItem := p.closeSpecific(itemListUnordered, 1)
-					if closeItem != "" {
-						buffer.WriteString(closeItem)
-						closed = true
-					}
-				}
-				if !closed {
-					closeDecrease := p.closeSpecific(itemListUnorderedDecrease, 1)
-					buffer.WriteString(closeDecrease)
-				}
-			}
-			if item.typ == itemListUnorderedDecrease {
-				closing := p.closeOthers(itemListUnorderedIncrease)
-				buffer.WriteString(closing)
-				//this is hacky
-
-				closingUnordered := p.closeSpecific(itemListUnordered, 1)
-				buffer.WriteString(closingUnordered)
-			}
-			if item.typ == itemListUnorderedIncrease {
-			}
-			if val, ok := itemTokens[item.typ]; ok {
-				buffer.WriteString(val[0])
-				p.openItemsStack.Push(item.typ)
-				p.openList[item.typ]++
+		case itemListUnorderedIncrease, itemListUnorderedSameAsLast, itemListUnorderedDecrease:
+			p.openListItem(pos, item.val)
+			break
+		case itemListOrderedIncrease, itemListOrderedSameAsLast, itemListOrderedDecrease:
+			p.openListItem(pos, item.val)
+			break
+		case itemListUnordered, itemListOrdered:
+			//the item following an increase, it was opened along with the list
+			break
+		case itemDefinitionTerm:
+			if p.isOpen(itemDefinitionList) {
+				p.closeTo(itemDefinitionList)
 			} else {
-				fmt.Errorf("Can not find item token")
+				p.closeAll()
+				p.open(itemDefinitionList, newDefinitionList(pos))
 			}
-
-			p.depth = listLength //set to current depth
+			p.open(item.typ, newDefinitionTerm(pos))
 			break
-
-		case itemListOrdered, itemListOrderedIncrease, itemListOrderedSameAsLast, itemListOrderedDecrease:
-			var listLength = len(item.val)
-			if item.typ == itemListOrderedSameAsLast {
-				var closed = false
-				closeSame := p.closeSpecific(itemListOrderedSameAsLast, 1)
-				if closeSame != "" {
-					buffer.WriteString(closeSame)
-					closed = true
-				}
-				if !closed {
-					closeItem := p.closeSpecific(itemListOrdered, 1)
-					if closeItem != "" {
-						buffer.WriteString(closeItem)
-						closed = true
-					}
-				}
-				if !closed {
-					closeDecrease := p.closeSpecific(itemListOrderedDecrease, 1)
-					buffer.WriteString(closeDecrease)
-				}
-			}
-			if item.typ == itemListOrderedDecrease {
-				closing := p.closeOthers(itemListOrderedIncrease)
-				buffer.WriteString(closing)
-
-				closingOrdered := p.closeSpecific(itemListOrdered, 1)
-				buffer.WriteString(closingOrdered)
+		case itemDefinition:
+			if !p.isOpen(itemDefinitionList) {
+				//a definition without a term is just text
+				p.text(pos, item.val, lineStart)
+				break
 			}
-			if item.typ == itemListOrderedIncrease {
+			p.openDefinition(pos)
+			break
+		case itemIndent:
+			if item.val == ":" && p.isOpen(itemDefinitionList) {
+				//a : line following a term is its definition
+				p.openDefinition(pos)
+				break
 			}
-			if val, ok := itemTokens[item.typ]; ok {
-				buffer.WriteString(val[0])
-				p.openItemsStack.Push(item.typ)
-				p.openList[item.typ]++
+			p.openNested(item.typ, len(item.val), func() appender { return newIndent(pos) })
+			break
+		case itemBlockquote:
+			p.openNested(item.typ, len(item.val), func() appender { return newBlockquote(pos) })
+			break
+		case itemTableRowStart:
+			row := newRow(pos)
+			if p.isOpen(itemTable) {
+				p.closeTo(itemTable)
 			} else {
-				fmt.Errorf("Can not find item token")
+				p.closeAll()
+				p.open(itemTable, newTable(pos))
+				p.headRow = row
 			}
-			p.depth = listLength //set to current depth
-			break
-		case itemTableRowStart, itemTableRowEnd, itemTableHeaderItem, itemTableItem:
-			if item.typ == itemTableRowStart {
-				if !p.isOpen(itemTable) {
-					buffer.WriteString(itemTokens[itemTable][0])
-					p.openItemsStack.Push(itemTable)
-					p.openList[itemTable]++
-				}
-				buffer.WriteString(itemTokens[itemTableRow][0])
-				p.openList[itemTableRow]++
+			p.open(itemTableRow, row)
+			break
+		case itemTableHeaderItem, itemTableItem:
+			if !p.isOpen(itemTableRow) {
+				//a | outside of a table is just text
+				p.text(pos, item.val, lineStart)
+				break
 			}
-			//explicit row end
-			if item.typ == itemTableRowEnd {
-				if p.isOpen(itemTableItem) {
-					buffer.WriteString(itemTokens[itemTableItem][1])
-					p.openList[itemTableItem]--
-				}
-				if p.isOpen(itemTableHeaderItem) {
-					buffer.WriteString(itemTokens[itemTableHeaderItem][1])
-					p.openList[itemTableHeaderItem]--
-				}
-
-				buffer.WriteString(itemTokens[itemTableRow][1])
-
-				p.openList[itemTableRow]--
+			p.closeCell()
+			p.closeTo(itemTableRow)
+			header := item.typ == itemTableHeaderItem
+			if row := p.current().(*Row); row == p.headRow {
+				row.Header = header && (len(row.Nodes) == 0 || row.Header)
 			}
-			if item.typ == itemTableHeaderItem || item.typ == itemTableItem {
-				if val, ok := itemTokens[item.typ]; ok {
-					if p.isOpen(item.typ) {
-						buffer.WriteString(val[1])
-
-						p.openList[item.typ]--
-
-					}
-					buffer.WriteString(val[0])
-					p.openList[item.typ]++
-				} else {
-					fmt.Errorf("Can not find item token")
-				}
+			cell := newCell(pos, header)
+			cell.Colspan, cell.Align = parseCellFormat(item.val)
+			p.open(item.typ, cell)
+			p.cell = cell
+			break
+		case itemTableRowEnd:
+			//explicit row end
+			if !p.isOpen(itemTableRow) {
+				p.text(pos, item.val, lineStart)
+				break
 			}
+			p.closeCell()
+			p.closeTo(itemTable)
 			break
 		case itemImage:
-			imageHtml := p.translateWikiImageToHtml(item.val)
-			buffer.WriteString(imageHtml)
+			p.openInline(pos)
+			p.add(p.translateWikiImage(item))
 			break
 		case itemLink:
-			linkHtml := p.translateWikiLinkToHtml(item.val)
-			buffer.WriteString(linkHtml)
+			p.openInline(pos)
+			p.add(p.translateWikiLink(item))
 			break
 		case itemFreeLink:
-			linkHtml := p.makeHtmlLink(item.val, item.val)
-			buffer.WriteString(linkHtml)
+			p.openInline(pos)
+			p.add(newLink(pos, item.val, item.val))
+			break
+		case itemWikiWord:
+			p.openInline(pos)
+			link := newLink(pos, item.val, item.val)
+			link.Internal = true
+			p.add(link)
 			break
 		case itemHorizontalRule:
-			buffer.WriteString("<hr>")
+			p.closeAll()
+			p.add(newHorizontalRule(pos))
 			break
 		case itemWikiLineBreak:
-			buffer.WriteString("<br />")
+			p.openInline(pos)
+			p.add(newLineBreak(pos))
 			break
 
 		case itemNoWikiOpen:
-			buffer.WriteString("<pre>")
-			//TODO: what to do if nowiki is not closed. do we track hanging nowiki tags?
+			p.noWiki = newNoWiki(pos)
+			p.openInline(pos)
+			p.add(p.noWiki)
+			break
+		case itemNoWikiBlockOpen:
+			p.noWiki = newNoWiki(pos)
+			p.noWiki.Block = true
+			p.noWiki.Lang, _ = noWikiLang(item.val[len("{{{"):])
+			p.closeAll()
+			p.add(p.noWiki)
+			break
+		case itemNoWikiText:
+			if p.noWiki != nil {
+				if p.noWiki.Block {
+					p.noWiki.Text += unindentNoWikiClose(trimBlockNoWiki(item.val))
+				} else {
+					p.noWiki.Text += item.val
+				}
+			} else {
+				p.text(pos, item.val, lineStart)
+			}
+			break
+		case itemNoWikiClose, itemNoWikiBlockClose:
+			p.noWiki = nil
+			break
+		case itemPlaceholder:
+			block := lineStart && p.isRestOfLineBlank(item.pos+len(item.val))
+			p.placeholder(pos, item.val, "", item.val, block)
 			break
-		case itemNoWikiClose:
-			buffer.WriteString("</pre>")
+		case itemPlaceholderBlockOpen:
+			p.macro = item
+			p.macroBody = ""
+			break
+		case itemPlaceholderBlockBody:
+			p.macroBody = trimBlockNoWiki(item.val)
+			break
+		case itemPlaceholderBlockClose:
+			source := p.input[p.macro.pos : item.pos+len(item.val)]
+			p.placeholder(Pos(p.offset+p.macro.pos), p.macro.val, p.macroBody, source, true)
 			break
 		case itemEscape:
 			//don't do anything with the itemEscape, we just want to make sure we don't write it (~) out
 			break
 		case itemEscapeText:
-			buffer.WriteString(item.val)
+			p.openInline(pos)
+			p.add(newText(pos, item.val))
 			break
 		case itemNewLine:
-			//TODO: anything here?
+			p.lineStart = true
+			if p.isHeadingOpen() {
+				//a heading ends at the end of its line
+				p.closeAll()
+			} else if p.isOpen(itemTableRow) {
+				p.closeCell()
+				p.closeTo(itemTable)
+			} else if p.openItemsStack.Len() > 0 && !p.endsWithLineBreak() {
+				p.softBreak = true
+			}
 			break
 		case itemEOF:
-			buffer.WriteString(p.closeAtDoubleLineBreak())
-			break Done
+			p.closeCell()
+			p.closeAll()
+			return p.doc, nil
 		case itemError:
-			break Done
+			p.closeCell()
+			p.closeAll()
+			return p.doc, newParseError(p.input, item.pos, item.val)
 		default:
-			buffer.WriteString(item.val)
+			p.text(pos, item.val, lineStart)
 			break
 		}
 	}
-	return buffer.String(), nil
 }
 
-//translateWikiImageToHtml will given this {{src|alt}}
-//returns this <img src="src" alt="alt" />
-func (p *parser) translateWikiImageToHtml(wikiImage string) string {
-	wikiImage = strings.TrimPrefix(wikiImage, "{{")
+//Transform processes an input string of creole markdown and returns html or error.
+//If the input is malformed, the html for the input up to the problem is returned with a *ParseError.
+func Transform(input string) (output string, terror error) {
+	return TransformWithOptions(input, Options{})
+}
+
+//TransformWithOptions is Transform, configured by opts.
+func TransformWithOptions(input string, opts Options) (output string, terror error) {
+	var buffer bytes.Buffer
+	err := TransformTo(&buffer, strings.NewReader(input), opts)
+	return buffer.String(), err
+}
+
+//endsWithLineBreak checks if the last node added to the innermost open node is a forced line break
+func (p *parser) endsWithLineBreak() bool {
+	if c, ok := p.current().(Container); ok {
+		children := c.Children()
+		if len(children) > 0 {
+			_, ok := children[len(children)-1].(*LineBreak)
+			return ok
+		}
+	}
+	return false
+}
+
+//translateWikiImage will given this {{src|alt}}
+//returns an Image with src as the location and alt as the alt text
+func (p *parser) translateWikiImage(i item) *Image {
+	wikiImage := strings.TrimPrefix(i.val, "{{")
 	wikiImage = strings.TrimSuffix(wikiImage, "}}")
 	var imageParts = strings.Split(wikiImage, "|")
 	var alt = ""
 	if len(imageParts) == 2 {
 		alt = imageParts[1]
 	}
-	return "<img src=\"" + imageParts[0] + "\" alt=\"" + alt + "\" />"
+	return newImage(Pos(p.offset+i.pos), imageParts[0], alt)
 }
 
-//translateWikiLinkToHtml will given this [[href|text]]
-//returns this <a href="href"/>text</a>
-func (p *parser) translateWikiLinkToHtml(wikiLink string) string {
-	wikiLink = strings.TrimPrefix(wikiLink, "[[")
+//translateWikiLink will given this [[href|text]]
+//returns a Link to href with text as the link text
+func (p *parser) translateWikiLink(i item) *Link {
+	wikiLink := strings.TrimPrefix(i.val, "[[")
 	wikiLink = strings.TrimSuffix(wikiLink, "]]")
 	var linkParts = strings.Split(wikiLink, "|")
 	var text = linkParts[0]
 	if len(linkParts) == 2 {
 		text = linkParts[1]
 	}
-	return p.makeHtmlLink(linkParts[0], text)
+	link := newLink(Pos(p.offset+i.pos), linkParts[0], text)
+	link.Internal = !isExternalLink(link.Location)
+	return link
 }
 
-//makeHtmlLink fabricates an simple html link
-func (p *parser) makeHtmlLink(href string, text string) string {
+//parseCellFormat returns the colspan and alignment of a cell from the markers lexed with its | or |=, e.g. |=<2> >
+func parseCellFormat(val string) (colspan int, align Align) {
+	val = strings.TrimLeft(val, "|=")
+	if n := colspanLength(val); n > 0 {
+		colspan, _ = strconv.Atoi(val[1 : n-1])
+		val = val[n:]
+	}
+	switch strings.TrimSpace(val) {
+	case "<":
+		align = AlignLeft
+	case ">":
+		align = AlignRight
+	case ":":
+		align = AlignCenter
+	}
+	return colspan, align
+}
 
-	return "<a href=\"" + href + "\" />" + text + "</a>"
+//closeCell finishes the last table cell opened, the : ending a |:centered:| cell is removed.
+//The cell may already have been closed by the end of its block, its content is complete either way.
+func (p *parser) closeCell() {
+	if p.cell != nil && p.cell.Align == AlignCenter {
+		p.cell.mergeText()
+		trimCenterColon(p.cell)
+	}
+	p.cell = nil
 }
 
-//isFollowingDoubleLineBreak checks if the current item follows a double line break
-func (p *parser) isFollowingDoubleLineBreak(current item) bool {
-	if len(p.items) == 1 {
-		//at the start of the input.
-		return true
+//trimCenterColon removes the : ending a |:centered:| cell
+func trimCenterColon(c *Cell) {
+	if len(c.Nodes) == 0 {
+		return
+	}
+	if t, ok := c.Nodes[len(c.Nodes)-1].(*Text); ok {
+		if s := strings.TrimRight(t.Text, " \t"); strings.HasSuffix(s, ":") {
+			t.Text = s[:len(s)-1]
+		}
 	}
-	newLineCount := 0
-	for i := len(p.items) - 2; i >= 0; i-- {
-		precedingItem := p.items[i]
+}
 
-		if precedingItem.typ == itemNewLine {
-			newLineCount++
-			if newLineCount > 1 {
-				return true
-			}
-			continue
+//placeholder expands the placeholder val, a <<toc>> or a registered macro, with its body if it is the block form.
+//Anything else, and macros that fail, are left as the source text.
+func (p *parser) placeholder(pos Pos, val string, body string, source string, block bool) {
+	name, args := parsePlaceholder(val)
+	if p.opts.TOC && strings.EqualFold(name, "toc") {
+		p.closeAll()
+		p.add(newTOC(pos))
+		return
+	}
+	fn := lookupMacro(name)
+	if name == "" || fn == nil {
+		p.text(pos, source, false)
+		return
+	}
+	ctx := &MacroContext{Name: name, Pos: pos, Block: block, Options: p.opts}
+	n, err := fn(ctx, parseMacroArgs(args), body)
+	if err != nil {
+		if p.opts.Warn != nil {
+			p.opts.Warn(Warning{Pos: pos, Msg: fmt.Sprintf("macro %q failed: %v", name, err)})
 		}
-		if precedingItem.typ != itemSpaceRun {
-			break
+		p.text(pos, source, false)
+		return
+	}
+	if n == nil {
+		return
+	}
+	if block && isInline(n) {
+		//inline content on a line of its own is a paragraph
+		p.closeAll()
+		p.open(itemText, newParagraph(pos))
+		p.add(n)
+		p.closeAll()
+		return
+	}
+	if block {
+		p.closeAll()
+	} else {
+		p.openInline(pos)
+		if p.softBreak {
+			p.add(newSoftBreak(pos))
 		}
 	}
-	return false
+	p.add(n)
 }
 
-//isParagraphStart checks if the current item is at the start of a paragraph
-func (p *parser) isParagraphStart(current item) bool {
+//isRestOfLineBlank checks if there is only whitespace from pos to the end of the line
+func (p *parser) isRestOfLineBlank(pos int) bool {
+	rest := p.input[pos:]
+	if i := strings.IndexAny(rest, "\r\n"); i >= 0 {
+		rest = rest[:i]
+	}
+	return strings.TrimSpace(rest) == ""
+}
 
-	if current.typ == itemText {
-		if len(p.items) == 1 {
-			//at the start of the input.
-			return true
-		}
-		newLineCount := 0
-		for i := len(p.items) - 2; i >= 0; i-- {
-			precedingItem := p.items[i]
-
-			if precedingItem.typ == itemNewLine {
-				newLineCount++
-				if newLineCount > 1 {
-					return true
-				}
-				continue
-			}
-			if precedingItem.typ != itemSpaceRun {
-				break
-			}
-		}
+//isExternalLink checks if location is a url, e.g. http://example.com or mailto:a@example.com, rather than the name of a page
+func isExternalLink(location string) bool {
+	scheme, ok := urlScheme(location)
+	if !ok {
+		return strings.HasPrefix(location, "/") || strings.HasPrefix(location, "#")
 	}
-	return false
+	rest := location[strings.Index(location, ":")+1:]
+	return strings.HasPrefix(rest, "//") || scheme == "mailto"
+}
+
+//isFollowingDoubleLineBreak checks if the current item follows a double line break, or only whitespace at the start of the input
+func (p *parser) isFollowingDoubleLineBreak() bool {
+	return p.blank || p.newLines > 1
+}
+
+//trackLineBreaks counts the newlines in the run of whitespace items ending with item
+//A single space or tab is lexed as text, so it is whitespace too, making any line of only spaces and tabs blank.
+func (p *parser) trackLineBreaks(item item) {
+	switch {
+	case item.typ == itemNewLine:
+		p.newLines++
+	case item.typ == itemSpaceRun:
+	case item.typ == itemText && isBlank(item.val):
+	default:
+		p.newLines = 0
+		p.blank = false
+	}
+}
+
+//isBlank checks if s is only spaces, tabs and line breaks, e.g. a line that ends a paragraph
+func isBlank(s string) bool {
+	return strings.Trim(s, " \t\r\n") == ""
 }
 
 //nextNonSpace scans forward until the nextNonSpace
@@ -557,6 +837,7 @@ type openItems struct {
 
 type openItem struct {
 	typ  itemType
+	node appender
 	next *openItem
 }
 
@@ -564,16 +845,24 @@ func (ois *openItems) Len() int {
 	return ois.size
 }
 
-func (ois *openItems) Push(typ itemType) {
-	ois.top = &openItem{typ, ois.top}
+func (ois *openItems) Push(typ itemType, node appender) {
+	ois.top = &openItem{typ, node, ois.top}
 	ois.size++
 }
 
-func (ois *openItems) Pop() (typ itemType) {
+func (ois *openItems) Pop() (typ itemType, node appender) {
 	if ois.size > 0 {
-		typ, ois.top = ois.top.typ, ois.top.next
+		typ, node, ois.top = ois.top.typ, ois.top.node, ois.top.next
 		ois.size--
 		return
 	}
-	return itemUnset
+	return itemUnset, nil
+}
+
+// Peek returns the type of the top item without removing it
+func (ois *openItems) Peek() (typ itemType, ok bool) {
+	if ois.size > 0 {
+		return ois.top.typ, true
+	}
+	return itemUnset, false
 }
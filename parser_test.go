@@ -1,6 +1,13 @@
 package cajun
 
-import "testing"
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+)
 
 type parserTest struct {
 	name   string
@@ -10,7 +17,7 @@ type parserTest struct {
 
 var parserTests = []parserTest{
 	{"empty", "", ""},
-	{"spaces", "   ", "   "},
+	{"spaces", "   ", ""},
 	{"heading1", "= Level 1 =", "<h1> Level 1 </h1>"},
 	{"should not be heading1. no space", "=Level 1 =", "<p>=Level 1 =</p>"},
 	{"heading2", "== Level 2 ==", "<h2> Level 2 </h2>"},
@@ -21,9 +28,9 @@ var parserTests = []parserTest{
 	{"heading6", "====== Level 6 ========", "<h6> Level 6 </h6>"},
 	{"heading: should close h1 as h3", "=== Level =", "<h3> Level </h3>"},
 	{"hr", "----", "<hr>"},
-	{"hr preceeded by space", "  ----", "  <hr>"},
-	{"hr preceeded by space, break, then text", "  ----  \n more", "  <hr>   more"},
-	{"hr followed by space", "----  ", "<hr>  "},
+	{"hr preceeded by space", "  ----", "<hr>"},
+	{"hr preceeded by space, break, then text", "  ----  \n more", "<hr><p> more</p>"},
+	{"hr followed by space", "----  ", "<hr>"},
 	{"hr too many dashes", "-----", "<p>-----</p>"},
 	{"text", `now is the time`, "<p>now is the time</p>"},
 	{"text with escaped bold", "hello-~**blah**-world", "<p>hello-**blah<strong>-world</strong></p>"},
@@ -43,7 +50,7 @@ var parserTests = []parserTest{
 	{"3 children", "* item1\n** item1.1\n** item1.2\n** item1.3", "<ul><li> item1<ul><li> item1.1</li><li> item1.2</li><li> item1.3</li></ul></li></ul>"},
 	{"unordered list - long", "* item1\n** item1.1\n** item1.2\n* item2 \n** item2.1\n** item2.2\n*** item2.2.1", "<ul><li> item1<ul><li> item1.1</li><li> item1.2</li></ul></li><li> item2 <ul><li> item2.1</li><li> item2.2<ul><li> item2.2.1</li></ul></li></ul></li></ul>"},
 	{"5 levels", "* 1\n** 2\n*** 3\n**** 4\n***** 5", "<ul><li> 1<ul><li> 2<ul><li> 3<ul><li> 4<ul><li> 5</li></ul></li></ul></li></ul></li></ul></li></ul>"},
-	{"multiline list items", "* 1\n test\n* 2\n test", "<ul><li> 1 test</li><li> 2 test</li></ul>"},
+	{"multiline list items", "* 1\n test\n* 2\n test", "<ul><li> 1\n test</li><li> 2\n test</li></ul>"},
 
 	{"ordered list simple", "# list item\n## child item", "<ol><li> list item<ol><li> child item</li></ol></li></ol>"},
 	{"ordered list - one child, in first parent", "# list item\n## child item\n# list item", "<ol><li> list item<ol><li> child item</li></ol></li><li> list item</li></ol>"},
@@ -53,12 +60,74 @@ var parserTests = []parserTest{
 	{"ordered 3 children", "# item1\n## item1.1\n## item1.2\n## item1.3", "<ol><li> item1<ol><li> item1.1</li><li> item1.2</li><li> item1.3</li></ol></li></ol>"},
 	{"ordered list - long", "# item1\n## item1.1\n## item1.2\n# item2 \n## item2.1\n## item2.2\n### item2.2.1", "<ol><li> item1<ol><li> item1.1</li><li> item1.2</li></ol></li><li> item2 <ol><li> item2.1</li><li> item2.2<ol><li> item2.2.1</li></ol></li></ol></li></ol>"},
 	{"ordered 5 levels", "# 1\n## 2\n### 3\n#### 4\n##### 5", "<ol><li> 1<ol><li> 2<ol><li> 3<ol><li> 4<ol><li> 5</li></ol></li></ol></li></ol></li></ol></li></ol>"},
-	{"multiline ordered list items", "# 1\n test\n# 2\n test", "<ol><li> 1 test</li><li> 2 test</li></ol>"},
-	{"image simple", "{{Red-Flower.jpg|here is a red flower}}", "<img src=\"Red-Flower.jpg\" alt=\"here is a red flower\" />"},
-	{"image simple no alt", "{{Red-Flower.jpg}}", "<img src=\"Red-Flower.jpg\" alt=\"\" />"},
-	{"link simple", "[[http://www.wikicreole.org|external links]]", "<a href=\"http://www.wikicreole.org\" />external links</a>"},
-	{"link simple", "[[http://www.wikicreole.org]]", "<a href=\"http://www.wikicreole.org\" />http://www.wikicreole.org</a>"},
-	{"free link simple", "this text has a link http://www.wikicreole.org to wiki creole", "<p>this text has a link <a href=\"http://www.wikicreole.org\" />http://www.wikicreole.org</a> to wiki creole</p>"},
+	{"multiline ordered list items", "# 1\n test\n# 2\n test", "<ol><li> 1\n test</li><li> 2\n test</li></ol>"},
+	{"image simple", "{{Red-Flower.jpg|here is a red flower}}", "<p><img src=\"Red-Flower.jpg\" alt=\"here is a red flower\" /></p>"},
+	{"image simple no alt", "{{Red-Flower.jpg}}", "<p><img src=\"Red-Flower.jpg\" alt=\"\" /></p>"},
+	{"link simple", "[[http://www.wikicreole.org|external links]]", "<p><a href=\"http://www.wikicreole.org\">external links</a></p>"},
+	{"link simple", "[[http://www.wikicreole.org]]", "<p><a href=\"http://www.wikicreole.org\">http://www.wikicreole.org</a></p>"},
+	{"free link simple", "this text has a link http://www.wikicreole.org to wiki creole", "<p>this text has a link <a href=\"http://www.wikicreole.org\">http://www.wikicreole.org</a> to wiki creole</p>"},
+	{"escape text", "<script>alert('x')</script> & more", "<p>&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt; &amp; more</p>"},
+	{"escape nowiki", "{{{<b>}}}", "<p><tt>&lt;b&gt;</tt></p>"},
+	{"escape image attributes", "{{a\"b.png|say \"hi\"}}", "<p><img src=\"a&#34;b.png\" alt=\"say &#34;hi&#34;\" /></p>"},
+	{"escape link attributes", "[[page\"><b>|x]]", "<p><a href=\"./page%22%3E%3Cb%3E\">x</a></p>"},
+	{"javascript link", "[[javascript://%0Aalert(1)|click]]", "<p>click</p>"},
+	{"javascript link, mixed case and whitespace", "[[ Java\tScript://%0Aalert(1)|click]]", "<p>click</p>"},
+	{"javascript page name", "[[javascript:alert(1)|click]]", "<p><a href=\"./javascript:alert%281%29\">click</a></p>"},
+	{"javascript image", "{{javascript:alert(1)|alt}}", "<p>alt</p>"},
+	{"data image", "{{data:image/png;base64,AAAA|alt}}", "<p>alt</p>"},
+	{"mailto link", "[[mailto:a@example.com|mail]]", "<p><a href=\"mailto:a@example.com\">mail</a></p>"},
+	{"heading without closing equals", "== Level 2\ntext", "<h2> Level 2</h2><p>text</p>"},
+	{"heading ends a paragraph", "text\n= Title", "<p>text</p><h1> Title</h1>"},
+	{"bold across line breaks", "**bold\nstill bold", "<p><strong>bold\nstill bold</strong></p>"},
+	{"bold ends with the paragraph", "**bold\n\nnot bold", "<p><strong>bold</strong></p><p>not bold</p>"},
+	{"list ends a paragraph", "text\n* item", "<p>text</p><ul><li> item</li></ul>"},
+	{"list indented", "  * a\n ** b", "<ul><li> a<ul><li> b</li></ul></li></ul>"},
+	{"list nested after space", "# a\n    ## b\n  ## c", "<ol><li> a<ol><li> b</li><li> c</li></ol></li></ol>"},
+	{"mixed list markers", "* a\n*# b\n*# c\n* d", "<ul><li> a<ol><li> b</li><li> c</li></ol></li><li> d</li></ul>"},
+	{"ordered list in unordered", "* a\n## b\n## c\n* d", "<ul><li> a<ol><li> b</li><li> c</li></ol></li><li> d</li></ul>"},
+	{"unordered list in ordered", "# a\n** b\n# c", "<ol><li> a<ul><li> b</li></ul></li><li> c</li></ol>"},
+	{"list drops two levels", "* a\n*# b\n*#* c\n* d", "<ul><li> a<ol><li> b<ul><li> c</li></ul></li></ol></li><li> d</li></ul>"},
+	{"mixed marker replaces a list", "# a\n#* b\n## c", "<ol><li> a<ul><li> b</li></ul><ol><li> c</li></ol></li></ol>"},
+	{"mixed marker replaces an outer list", "* a\n*# b\n#* c", "<ul><li> a<ol><li> b</li></ol></li></ul><ol><li><ul><li> c</li></ul></li></ol>"},
+	{"mixed marker too deep", "*# a", "<p>*# a</p>"},
+	{"internal link", "[[internal links]]", "<p><a href=\"./internal%20links\">internal links</a></p>"},
+	{"namespaced internal links", "[[Help:Contents]] [[WikiCreole:Creole1.0TestCases|tests]] [[Page#Getting started|a]] [[Sub/page]]", "<p><a href=\"./Help:Contents\">Help:Contents</a> <a href=\"./WikiCreole:Creole1.0TestCases\">tests</a> <a href=\"./Page#Getting%20started\">a</a> <a href=\"./Sub/page\">Sub/page</a></p>"},
+	{"block nowiki", "{{{\n**a**\n}}}", "<pre>**a**</pre>"},
+	{"inline nowiki", "a {{{**b**}}} c", "<p>a <tt>**b**</tt> c</p>"},
+	{"inline nowiki keeps the paragraph", "use\n{{{go test}}}\nto test", "<p>use\n<tt>go test</tt>\nto test</p>"},
+	{"inline nowiki at line start", "{{{a}}} b", "<p><tt>a</tt> b</p>"},
+	{"inline nowiki closing braces", "{{{if (a) {b}}}} c", "<p><tt>if (a) {b}</tt> c</p>"},
+	{"whitespace line ends a paragraph", "**a\n \t\nb**", "<p><strong>a</strong></p><p>b<strong></strong></p>"},
+	{"block nowiki ends a paragraph", "a\n{{{\nb\n}}}\nc", "<p>a</p><pre>b</pre><p>c</p>"},
+	{"block nowiki with braces", "{{{\nif a {{{b}}}\n }}}\n}}}", "<pre>if a {{{b}}}\n}}}</pre>"},
+	{"block nowiki with a language", "{{{#!go\nif a < b {\n}\n}}}", "<pre><code class=\"language-go\">if a &lt; b {\n}</code></pre>"},
+	{"block nowiki with a shebang", "{{{\n#!/bin/sh\n}}}", "<pre>#!/bin/sh</pre>"},
+	{"not a language", "{{{#!a b\nc\n}}}", "<p><tt>#!a b\nc\n</tt></p>"},
+	{"block nowiki needs a closing line", "{{{\na }}}", "<p><tt>\na </tt></p>"},
+	{"escaped free link", "~http://a.com/ b", "<p>http://a.com/ b</p>"},
+	{"escaped tilde", "~~a", "<p>~a</p>"},
+	{"tilde alone", "a ~", "<p>a ~</p>"},
+	{"not italic url", "ftp://a.com and https://b.com", "<p><a href=\"ftp://a.com\">ftp://a.com</a> and <a href=\"https://b.com\">https://b.com</a></p>"},
+	{"free link schemes", "mailto:a@b.c, HTTPS://b.com/x?y=1. news:c", "<p><a href=\"mailto:a@b.c\">mailto:a@b.c</a>, <a href=\"HTTPS://b.com/x?y=1\">HTTPS://b.com/x?y=1</a>. news:c</p>"},
+	{"free link at the end", "see http://a.com", "<p>see <a href=\"http://a.com\">http://a.com</a></p>"},
+	{"free link at the end of a line", "http://a.com/\nb", "<p><a href=\"http://a.com/\">http://a.com/</a>\nb</p>"},
+	{"free link in parentheses", "(see http://a.org/Go_(language)).", "<p>(see <a href=\"http://a.org/Go_(language)\">http://a.org/Go_(language)</a>).</p>"},
+	{"free link without a host", "see http:// and mailto: now", "<p>see http:// and mailto: now</p>"},
+	{"free link trailing punctuation", "http://a.com/x?!", "<p><a href=\"http://a.com/x\">http://a.com/x</a>?!</p>"},
+	{"scheme alone", "http: and mailto:.", "<p>http: and mailto:.</p>"},
+	{"italic unknown scheme", "foo://bar", "<p>foo:<em>bar</em></p>"},
+	{"table", "|=a|=b|\n|c|d|", "<table><thead><tr><th>a</th><th>b</th></tr></thead><tbody><tr><td>c</td><td>d</td></tr></tbody></table>"},
+	{"table without trailing pipes", "|a|b\n|c|d", "<table><tr><td>a</td><td>b</td></tr><tr><td>c</td><td>d</td></tr></table>"},
+	{"table empty cells", "||a||\n|b|||", "<table><tr><td></td><td>a</td><td></td></tr><tr><td>b</td><td></td><td></td></tr></table>"},
+	{"table indented", "  |a|\n  |b|", "<table><tr><td>a</td></tr><tr><td>b</td></tr></table>"},
+	{"table mixed header and data cells", "|=a|b|\n|c|=d|", "<table><tr><th>a</th><td>b</td></tr><tr><td>c</td><th>d</th></tr></table>"},
+	{"table only headers", "|=a|=b|", "<table><thead><tr><th>a</th><th>b</th></tr></thead><tbody></tbody></table>"},
+	{"table header row later", "|a|\n|=b|", "<table><tr><td>a</td></tr><tr><th>b</th></tr></table>"},
+	{"table inline markup", "|[[p|t]]|{{i.png|a}}|**b** //i//|{{{x|y}}}|a\\\\b|~|", "<table><tr><td><a href=\"./p\">t</a></td><td><img src=\"i.png\" alt=\"a\" /></td><td><strong>b</strong> <em>i</em></td><td><tt>x|y</tt></td><td>a<br />b</td><td>|</td></tr></table>"},
+	{"table formatting ends with the cell", "|**a|b|", "<table><tr><td><strong>a</strong></td><td>b</td></tr></table>"},
+	{"table ends a paragraph", "text\n|a|", "<p>text</p><table><tr><td>a</td></tr></table>"},
+	{"pipe outside of a table", "a | b", "<p>a | b</p>"},
+	{"windows line endings", "a\r\nb\r\n\r\nc", "<p>a\nb</p><p>c</p>"},
 }
 
 func TestParser(t *testing.T) {
@@ -71,9 +140,402 @@ func TestParser(t *testing.T) {
 	}
 }
 
-//func TestParserLarge(t *testing.T) {
-//	dat, _ := ioutil.ReadFile("./creole1.0test.txt")
-//	//	fmt.Print(string(dat))
-//	output, _ := Transform(string(dat))
-//	fmt.Print("-------------\n", output)
-//}
+func TestAllowedSchemes(t *testing.T) {
+	doc, _ := Parse("{{data:text/plain,hi|a}} [[https://example.com|b]]")
+	var buffer bytes.Buffer
+	Render(&buffer, doc, &HTMLRenderer{AllowedSchemes: []string{"data"}})
+	expected := "<p><img src=\"data:text/plain,hi\" alt=\"a\" /> b</p>"
+	if buffer.String() != expected {
+		t.Errorf("got\n\t%v\nexpected\n\t%v", buffer.String(), expected)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	output, err := Transform("= Title =\n\nsome **text** [[broken\n")
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected a *ParseError, got %v", err)
+	}
+	expected := ParseError{Offset: 25, Line: 3, Column: 15, Msg: "unclosed link, expected ]] before the end of the line"}
+	if *perr != expected {
+		t.Errorf("got\n\t%+v\nexpected\n\t%+v", *perr, expected)
+	}
+	if perr.Error() != "creole:3:15: unclosed link, expected ]] before the end of the line" {
+		t.Errorf("unexpected message %q", perr.Error())
+	}
+	if output != "<h1> Title </h1><p>some <strong>text</strong> </p>" {
+		t.Errorf("unexpected output %q", output)
+	}
+}
+
+type prefixResolver string
+
+func (r prefixResolver) ResolveLink(location string) (string, bool) {
+	return string(r) + location, true
+}
+
+func (r prefixResolver) ResolveImage(location string) (*ImageSource, error) {
+	return &ImageSource{URL: string(r) + location}, nil
+}
+
+type optionsTest struct {
+	name   string
+	input  string
+	opts   Options
+	output string
+}
+
+var optionsTests = []optionsTest{
+	{"defaults", "a\nb", Options{}, "<p>a\nb</p>"},
+	{"hard wrap", "a\nb\\\\\nc\n\nd", Options{HardWrap: true}, "<p>a<br />b<br />c</p><p>d</p>"},
+	{"free link schemes", "gopher://b.com svn+ssh://c.org/r x-y.z:a https://d.com", Options{FreeLinkSchemes: []string{"gopher", "svn+ssh", "x-y.z"}, Unsafe: true}, "<p><a href=\"gopher://b.com\">gopher://b.com</a> <a href=\"svn+ssh://c.org/r\">svn+ssh://c.org/r</a> <a href=\"x-y.z:a\">x-y.z:a</a> https://d.com</p>"},
+	{"free link schemes, other schemes", "ftp://a.com and foo://bar", Options{FreeLinkSchemes: []string{"gopher"}}, "<p>ftp://a.com and foo:<em>bar</em></p>"},
+	{"free link scheme not allowed", "gopher://b.com", Options{FreeLinkSchemes: []string{"gopher"}}, "<p>gopher://b.com</p>"},
+	{"wiki words", "See FrontPage, RecentChanges2 and WikiWord's.", Options{Extensions: ExtensionWikiWords}, "<p>See <a href=\"./FrontPage\">FrontPage</a>, <a href=\"./RecentChanges2\">RecentChanges2</a> and <a href=\"./WikiWord\">WikiWord</a>&#39;s.</p>"},
+	{"not wiki words", "Wiki HTTPServer iPhone xFrontPage WikiW ÉtéPage", Options{Extensions: ExtensionWikiWords}, "<p>Wiki HTTPServer iPhone xFrontPage WikiW <a href=\"./%C3%89t%C3%A9Page\">ÉtéPage</a></p>"},
+	{"escaped wiki word", "~FrontPage ~xFrontPage", Options{Extensions: ExtensionWikiWords}, "<p>FrontPage xFrontPage</p>"},
+	{"wiki words resolved", "**FrontPage** [[http://a.com/FrontPage|FrontPage]]", Options{Extensions: ExtensionWikiWords, LinkResolver: WikiResolver{Prefix: "/wiki/"}}, "<p><strong><a href=\"/wiki/FrontPage\">FrontPage</a></strong> <a href=\"http://a.com/FrontPage\">FrontPage</a></p>"},
+	{"wiki words off by default", "FrontPage", Options{}, "<p>FrontPage</p>"},
+	{"table cells", "|=<2>Total|\n|= >1|>2|:3:|<4|<3>:5 :", Options{Extensions: ExtensionTableCells}, "<table><thead><tr><th colspan=\"2\">Total</th></tr></thead><tbody><tr><th style=\"text-align: right\">1</th><td style=\"text-align: right\">2</td><td style=\"text-align: center\">3</td><td style=\"text-align: left\">4</td><td colspan=\"3\" style=\"text-align: center\">5 </td></tr></tbody></table>"},
+	{"centered table cells with spaces", "|: a :|:b c :|: d|\n|: [[e]] :", Options{Extensions: ExtensionTableCells}, "<table><tr><td style=\"text-align: center\"> a </td><td style=\"text-align: center\">b c </td><td>: d</td></tr><tr><td style=\"text-align: center\"> <a href=\"./e\">e</a> </td></tr></table>"},
+	{"table cells need content after the marker", "|> |< 5|<<date>>|", Options{Extensions: ExtensionTableCells}, "<table><tr><td>&gt; </td><td>&lt; 5</td><td>2026-10-17</td></tr></table>"},
+	{"table cells off by default", "|<2>a|>b|", Options{}, "<table><tr><td>&lt;2&gt;a</td><td>&gt;b</td></tr></table>"},
+	{"definition lists", "; Creole : a wiki markup\n; Go\n: a language\n: a game", Options{Extensions: ExtensionDefinitionLists}, "<dl><dt> Creole </dt><dd> a wiki markup</dd><dt> Go</dt><dd> a language</dd><dd> a game</dd></dl>"},
+	{"definition lists off by default", "; a : b", Options{}, "<p>; a : b</p>"},
+	{"blockquotes", "> //a//\n>> b", Options{Extensions: ExtensionBlockquotes}, "<blockquote> <em>a</em><blockquote> b</blockquote></blockquote>"},
+	{"indent", ": a\n::b", Options{Extensions: ExtensionIndent}, "<div class=\"indent\"> a<div class=\"indent\">b</div></div>"},
+	{"indent without definition lists", "; a\n: b", Options{Extensions: ExtensionIndent}, "<p>; a</p><div class=\"indent\"> b</div>"},
+	{"blockquotes and indent off by default", "> a\n: b", Options{}, "<p>&gt; a\n: b</p>"},
+	{"unsafe", "[[javascript://x|a]] {{javascript:x()|b}}", Options{Unsafe: true}, "<p><a href=\"javascript://x\">a</a> <img src=\"javascript:x()\" alt=\"b\" /></p>"},
+	{"allowed schemes", "[[https://a.com|a]] [[ftp://a.com|b]]", Options{AllowedSchemes: []string{"ftp"}}, "<p>a <a href=\"ftp://a.com\">b</a></p>"},
+	{"link resolver", "[[Page]]", Options{LinkResolver: prefixResolver("/wiki/")}, "<p><a href=\"/wiki/Page\">Page</a></p>"},
+	{"link resolver, namespaced page", "[[Help:Contents]] [[WikiCreole:Creole1.0TestCases|tests]]", Options{LinkResolver: prefixResolver("/wiki/")}, "<p><a href=\"/wiki/Help:Contents\">Help:Contents</a> <a href=\"/wiki/WikiCreole:Creole1.0TestCases\">tests</a></p>"},
+	{"link resolver output is checked", "[[Page]]", Options{LinkResolver: LinkResolverFunc(func(target string) (string, bool) { return "javascript:x()", true })}, "<p>Page</p>"},
+	{"link resolver, external links untouched", "[[http://a.com/x|a]] [[mailto:a@b.c|b]] http://a.com .", Options{LinkResolver: prefixResolver("/wiki/")}, "<p><a href=\"http://a.com/x\">a</a> <a href=\"mailto:a@b.c\">b</a> <a href=\"http://a.com\">http://a.com</a> .</p>"},
+	{"wiki resolver", "[[internal links]] [[Ward Cunningham's|ward]]", Options{LinkResolver: WikiResolver{Prefix: "/wiki/"}}, "<p><a href=\"/wiki/Internal_links\">internal links</a> <a href=\"/wiki/Ward_Cunningham%27s\">ward</a></p>"},
+	{"wiki resolver, namespace and fragment", "[[Help:Contents]] [[Page#Getting started|a]] [[Sub/page]]", Options{LinkResolver: WikiResolver{Prefix: "/wiki/"}}, "<p><a href=\"/wiki/Help:Contents\">Help:Contents</a> <a href=\"/wiki/Page#Getting%20started\">a</a> <a href=\"/wiki/Sub/page\">Sub/page</a></p>"},
+	{"wiki resolver, missing page", "[[Home]] [[new page]]", Options{LinkResolver: WikiResolver{Prefix: "/wiki/", Exists: func(page string) bool { return page == "Home" }}}, "<p><a href=\"/wiki/Home\">Home</a> <a href=\"/wiki/New_page\" class=\"new\">new page</a></p>"},
+	{"missing link class", "[[new page]]", Options{MissingLinkClass: "red", LinkResolver: LinkResolverFunc(func(target string) (string, bool) { return "/" + target, false })}, "<p><a href=\"/new page\" class=\"red\">new page</a></p>"},
+	{"image resolver", "{{a.png|b}}", Options{ImageResolver: prefixResolver("https://cdn/")}, "<p><img src=\"https://cdn/a.png\" alt=\"b\" /></p>"},
+	{"image resolver with size", "{{a.png|b}}", Options{ImageResolver: ImageResolverFunc(func(location string) (*ImageSource, error) {
+		return &ImageSource{URL: "/img/" + location, Width: 40, Height: 30, SrcSet: "/img/2x/" + location + " 2x"}, nil
+	})}, "<p><img src=\"/img/a.png\" srcset=\"/img/2x/a.png 2x\" width=\"40\" height=\"30\" alt=\"b\" /></p>"},
+	{"image resolver rejects", "{{a.png|b}}", Options{ImageResolver: ImageResolverFunc(func(location string) (*ImageSource, error) {
+		return nil, fmt.Errorf("unknown")
+	})}, "<p>b</p>"},
+	{"heading id", "== Some **Title** ==", Options{HeadingID: strings.ToLower}, "<h2 id=\"some title\"> Some <strong>Title</strong> </h2>"},
+	{"heading ids", "= Intro =\n== Intro ==\n=== Getting //Started!// ===", Options{HeadingIDs: true}, "<h1 id=\"intro\"> Intro </h1><h2 id=\"intro-1\"> Intro </h2><h3 id=\"getting-started\"> Getting <em>Started!</em> </h3>"},
+	{"heading ids unique across blocks", "= A =\n\ntext\n\n= A =\n\n= A 1 =", Options{HeadingIDs: true}, "<h1 id=\"a\"> A </h1><p>text</p><h1 id=\"a-1\"> A </h1><h1 id=\"a-1-1\"> A 1 </h1>"},
+	{"heading id function made unique", "= a =\n= b =", Options{HeadingID: func(string) string { return "x" }}, "<h1 id=\"x\"> a </h1><h1 id=\"x-1\"> b </h1>"},
+	{"heading permalinks", "== Intro", Options{HeadingIDs: true, HeadingPermalinks: true}, "<h2 id=\"intro\"> Intro<a class=\"permalink\" href=\"#intro\">&para;</a></h2>"},
+	{"heading permalinks need ids", "== Intro", Options{HeadingPermalinks: true}, "<h2> Intro</h2>"},
+	{"additions", "^^a^^ ,,b,, ##c## __d__", Options{Extensions: ExtensionAdditions}, "<p><sup>a</sup> <sub>b</sub> <tt>c</tt> <u>d</u></p>"},
+	{"additions off by default", "^^a^^ ,,b,, ##c## __d__", Options{}, "<p>^^a^^ ,,b,, ##c## __d__</p>"},
+	{"highlighter", "{{{#!go\na := 1\n}}}\n{{{\nb\n}}}", Options{Highlighter: HighlighterFunc(func(lang, code string) (string, error) {
+		return "<span class=\"" + lang + "\">" + code + "</span>", nil
+	})}, "<pre><code class=\"language-go\"><span class=\"go\">a := 1</span></code></pre><pre>b</pre>"},
+	{"highlighter fails", "{{{#!go\n<a>\n}}}", Options{Highlighter: HighlighterFunc(func(lang, code string) (string, error) {
+		return "", fmt.Errorf("unknown")
+	})}, "<pre><code class=\"language-go\">&lt;a&gt;</code></pre>"},
+	{"custom renderer", "= a =", Options{Renderer: &anchoredHeadings{HTMLRenderer: &HTMLRenderer{}}}, "<h1 id=\"s1\"> a </h1>"},
+}
+
+func TestTransformWithOptions(t *testing.T) {
+	for _, test := range optionsTests {
+		output, err := TransformWithOptions(test.input, test.opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.output != output {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, output, test.output)
+		}
+	}
+}
+
+func TestImageWarning(t *testing.T) {
+	var warnings []Warning
+	opts := Options{
+		ImageResolver: ImageResolverFunc(func(location string) (*ImageSource, error) {
+			if location == "missing.png" {
+				return nil, fmt.Errorf("no such attachment")
+			}
+			if location == "gone.png" {
+				return nil, nil
+			}
+			if location == "script.png" {
+				return &ImageSource{URL: "javascript:x()"}, nil
+			}
+			return &ImageSource{URL: location}, nil
+		}),
+		Warn: func(w Warning) { warnings = append(warnings, w) },
+	}
+	output, err := TransformWithOptions("{{a.png}}\n\nsee {{missing.png|the chart}}\n\n{{gone.png|gone}}\n\n{{script.png|script}}", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "<p><img src=\"a.png\" alt=\"\" /></p><p>see the chart</p><p>gone</p><p>script</p>" {
+		t.Errorf("unexpected output %q", output)
+	}
+	expected := []Warning{
+		{Pos: 15, Msg: "image \"missing.png\" rejected: no such attachment"},
+		{Pos: 42, Msg: "image \"gone.png\" rejected: no image source"},
+		{Pos: 61, Msg: "image \"script.png\" rejected: scheme of \"javascript:x()\" not allowed"},
+	}
+	if fmt.Sprint(warnings) != fmt.Sprint(expected) {
+		t.Errorf("got\n\t%v\nexpected\n\t%v", warnings, expected)
+	}
+}
+
+func TestSlug(t *testing.T) {
+	for text, expected := range map[string]string{
+		"Intro":                "intro",
+		"Getting Started!":     "getting-started",
+		"  C++ & Go, 2nd ed. ": "c-go-2nd-ed",
+		"Élan vital":           "élan-vital",
+		"!!!":                  "section",
+		"":                     "section",
+	} {
+		if slug := Slug(text); slug != expected {
+			t.Errorf("%q: got %q expected %q", text, slug, expected)
+		}
+	}
+}
+
+func TestPageName(t *testing.T) {
+	for target, expected := range map[string]string{
+		"internal links":    "Internal_links",
+		"  spaced   out ":   "Spaced_out",
+		"élan":              "Élan",
+		"Ward Cunningham's": "Ward_Cunningham's",
+		"":                  "",
+	} {
+		if page := PageName(target); page != expected {
+			t.Errorf("%q: got %q expected %q", target, page, expected)
+		}
+	}
+}
+
+//TestParserLarge checks the rendering of the Creole 1.0 test cases, creole1.0test.txt, against creole1.0test.html.
+//The expected html is written from the rules the test cases describe, it is compared ignoring whitespace html doesn't show.
+func TestParserLarge(t *testing.T) {
+	input, err := ioutil.ReadFile("creole1.0test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("creole1.0test.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := Transform(string(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if normalizeHTML(output) != normalizeHTML(string(expected)) {
+		t.Errorf("got\n\t%v\nexpected\n\t%v", normalizeHTML(output), normalizeHTML(string(expected)))
+	}
+}
+
+var (
+	whitespace = regexp.MustCompile(`\s+`)
+	blockTag   = regexp.MustCompile(` ?(</?(?:h[1-6]|p|ul|ol|li|table|thead|tbody|tr|th|td|hr|pre|br)\b[^>]*>) ?`)
+)
+
+//normalizeHTML collapses whitespace, outside of <pre>, and drops it around block tags and <br />, where html doesn't show it
+func normalizeHTML(s string) string {
+	var b strings.Builder
+	for {
+		start, end := strings.Index(s, "<pre>"), strings.Index(s, "</pre>")
+		if start < 0 || end < start {
+			break
+		}
+		b.WriteString(blockTag.ReplaceAllString(whitespace.ReplaceAllString(s[:start], " "), "$1"))
+		b.WriteString(s[start:end])
+		s = s[end:]
+	}
+	b.WriteString(blockTag.ReplaceAllString(whitespace.ReplaceAllString(s, " "), "$1"))
+	return b.String()
+}
+
+var nodeName = map[NodeType]string{
+	NodeDocument:       "document",
+	NodeParagraph:      "paragraph",
+	NodeHeading:        "heading",
+	NodeList:           "list",
+	NodeListItem:       "item",
+	NodeTable:          "table",
+	NodeRow:            "row",
+	NodeCell:           "cell",
+	NodeLink:           "link",
+	NodeImage:          "image",
+	NodeBold:           "bold",
+	NodeItalics:        "italics",
+	NodeNoWiki:         "nowiki",
+	NodeHorizontalRule: "hr",
+	NodeLineBreak:      "br",
+	NodeSoftBreak:      "softbreak",
+	NodeText:           "text",
+	NodeTOC:            "toc",
+	NodeSuperscript:    "sup",
+	NodeSubscript:      "sub",
+	NodeMonospace:      "mono",
+	NodeUnderline:      "u",
+	NodeDefinitionList: "dl",
+	NodeDefinitionTerm: "dt",
+	NodeDefinition:     "dd",
+	NodeIndent:         "indent",
+	NodeBlockquote:     "quote",
+}
+
+// dump prints a node and its children in a compact lisp like form, to make expected trees easy to write
+func dump(n Node) string {
+	var parts = []string{nodeName[n.Type()]}
+	switch n := n.(type) {
+	case *Heading:
+		parts = append(parts, fmt.Sprint(n.Level))
+	case *List:
+		parts = append(parts, fmt.Sprint(n.Ordered))
+	case *Row:
+		parts = append(parts, fmt.Sprint(n.Header))
+	case *Cell:
+		parts = append(parts, fmt.Sprint(n.Header))
+	case *Link:
+		parts = append(parts, fmt.Sprintf("%q %v", n.Location, n.Internal))
+	case *Image:
+		parts = append(parts, fmt.Sprintf("%q %q", n.Location, n.Alt))
+	case *NoWiki:
+		parts = append(parts, fmt.Sprintf("%q", n.Text))
+	case *Text:
+		parts = append(parts, fmt.Sprintf("%q", n.Text))
+	}
+	if c, ok := n.(Container); ok {
+		for _, child := range c.Children() {
+			parts = append(parts, dump(child))
+		}
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+var parseTests = []parserTest{
+	{"empty", "", "(document)"},
+	{"text", "now is the time", `(document (paragraph (text "now is the time")))`},
+	{"heading", "== Level 2 ==", `(document (heading 2 (text " Level 2 ")))`},
+	{"bold and italics", "a **b //c**// d", `(document (paragraph (text "a ") (bold (text "b ") (italics (text "c"))) (text " d")))`},
+	{"escape merges text", "a~**b", `(document (paragraph (text "a**b")))`},
+	{"paragraphs", "one\n\ntwo", `(document (paragraph (text "one")) (paragraph (text "two")))`},
+	{"nested list", "* a\n** b\n* c", `(document (list false (item (text " a") (list false (item (text " b")))) (item (text " c"))))`},
+	{"ordered list", "# a\n# b", `(document (list true (item (text " a")) (item (text " b"))))`},
+	{"link", "[[page|text]]", `(document (paragraph (link "page" true (text "text"))))`},
+	{"external link", "[[http://a.com|text]]", `(document (paragraph (link "http://a.com" false (text "text"))))`},
+	{"free link", "see http://example.com now", `(document (paragraph (text "see ") (link "http://example.com" false (text "http://example.com")) (text " now")))`},
+	{"image", "{{a.png|alt}}", `(document (paragraph (image "a.png" "alt")))`},
+	{"nowiki", "a {{{ **b** }}} c", `(document (paragraph (text "a ") (nowiki " **b** ") (text " c")))`},
+	{"hr", "----", "(document (hr))"},
+	{"line break", "a\\\\b", `(document (paragraph (text "a") (br) (text "b")))`},
+	{"soft break", "a\nb\\\\\nc", `(document (paragraph (text "a") (softbreak) (text "b") (br) (text "c")))`},
+	{"soft break in list item", "* a\n b\n* c", `(document (list false (item (text " a") (softbreak) (text " b")) (item (text " c"))))`},
+	{"table", "|=h|=i|\n|a|b|\n", `(document (table (row true (cell true (text "h")) (cell true (text "i"))) (row false (cell false (text "a")) (cell false (text "b")))))`},
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range parseTests {
+		doc, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if output := dump(doc); test.output != output {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, output, test.output)
+		}
+	}
+}
+
+//testParseWithOptions checks the document trees of tests parsed with the extensions in opts
+func testParseWithOptions(t *testing.T, tests []parserTest, opts Options) {
+	for _, test := range tests {
+		doc, err := ParseWithOptions(test.input, opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if output := dump(doc); test.output != output {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, output, test.output)
+		}
+	}
+}
+
+var additionsTests = []parserTest{
+	{"superscript", "x^^2^^", `(document (paragraph (text "x") (sup (text "2"))))`},
+	{"subscript", "H,,2,,O", `(document (paragraph (text "H") (sub (text "2")) (text "O")))`},
+	{"monospace", "run ##go **test**##", `(document (paragraph (text "run ") (mono (text "go ") (bold (text "test")))))`},
+	{"underline", "__a__ b", `(document (paragraph (u (text "a")) (text " b")))`},
+	{"overlapping bold", "**^^a**^^", `(document (paragraph (bold (sup (text "a")))))`},
+	{"overlapping italics", "__//a__ b//", `(document (paragraph (u (italics (text "a"))) (text " b")))`},
+	{"unclosed", "__a\n\nb", `(document (paragraph (u (text "a"))) (paragraph (text "b")))`},
+	{"ordered list", "# a\n## b", `(document (list true (item (text " a") (list true (item (text " b"))))))`},
+	{"in a list item", "* ##a##", `(document (list false (item (text " ") (mono (text "a")))))`},
+}
+
+var definitionListTests = []parserTest{
+	{"term and definition", "; a : b", `(document (dl (dt (text " a ")) (dd (text " b"))))`},
+	{"definition lines", "; a\n: b\n: c\n; d", `(document (dl (dt (text " a")) (dd (text " b")) (dd (text " c")) (dt (text " d"))))`},
+	{"markup", "; **a** : //b//", `(document (dl (dt (text " ") (bold (text "a")) (text " ")) (dd (text " ") (italics (text "b")))))`},
+	{"only the first colon", "; a:b : c : d", `(document (dl (dt (text " a:b ")) (dd (text " c : d"))))`},
+	{"after a paragraph", "a\n; b : c\nd", `(document (paragraph (text "a")) (dl (dt (text " b ")) (dd (text " c") (softbreak) (text "d"))))`},
+	{"definition without a term", ": a\n\n; b\n\n: c", `(document (paragraph (text ": a")) (dl (dt (text " b"))) (paragraph (text ": c")))`},
+	{"empty term", ";\n; ", `(document (paragraph (text ";") (softbreak) (text "; ")))`},
+}
+
+func TestParseDefinitionLists(t *testing.T) {
+	testParseWithOptions(t, definitionListTests, Options{Extensions: ExtensionDefinitionLists})
+}
+
+var nestingTests = []parserTest{
+	{"quote", "> a\n> b", `(document (quote (text " a") (softbreak) (text " b")))`},
+	{"nested quote", "> a\n>> b\n> c", `(document (quote (text " a") (quote (text " b")) (softbreak) (text " c")))`},
+	{"deeper first", ">> a", `(document (quote (quote (text " a"))))`},
+	{"markup", "> **a\n> b** [[C]]", `(document (quote (text " ") (bold (text "a") (softbreak) (text " b")) (text " ") (link "C" true (text "C"))))`},
+	{"indent", ": a\n:: b", `(document (indent (text " a") (indent (text " b"))))`},
+	{"blank line", "> a\n\n> b", `(document (quote (text " a")) (quote (text " b")))`},
+	{"after a paragraph", "a\n> b\nc", `(document (paragraph (text "a")) (quote (text " b") (softbreak) (text "c")))`},
+	{"indent after quote", "> a\n: b", `(document (quote (text " a")) (indent (text " b")))`},
+	{"definition", "; a\n: b\n:: c", `(document (dl (dt (text " a")) (dd (text " b"))) (indent (indent (text " c"))))`},
+	{"not at line start", "a > b : c", `(document (paragraph (text "a > b : c")))`},
+}
+
+func TestParseNesting(t *testing.T) {
+	testParseWithOptions(t, nestingTests, Options{Extensions: ExtensionIndent | ExtensionBlockquotes | ExtensionDefinitionLists})
+}
+
+func TestParseAdditions(t *testing.T) {
+	testParseWithOptions(t, additionsTests, Options{Extensions: ExtensionAdditions})
+}
+
+func benchmarkTransform(b *testing.B, input string) {
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Transform(input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTransform1MB(b *testing.B) {
+	benchmarkTransform(b, benchmarkInput(b, 1<<20))
+}
+
+func BenchmarkParseLeadingWhitespace(b *testing.B) {
+	input := strings.Repeat("  \n", 1<<14) + "text"
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		Parse(input)
+	}
+}
+
+func BenchmarkParseLongParagraph(b *testing.B) {
+	input := strings.Repeat("a ~b ", 1<<16)
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		Parse(input)
+	}
+}
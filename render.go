@@ -0,0 +1,176 @@
+package cajun
+
+import (
+	"io"
+)
+
+// Renderer writes the output for each kind of node in a document tree.
+//
+// Nodes that have children are visited twice, once with entering set before
+// the children are rendered and once with entering unset after them. Nodes
+// without children are visited once.
+//
+// To change how a single kind of node is rendered, embed *HTMLRenderer in a
+// struct and define only the methods that should differ.
+type Renderer interface {
+	Document(w io.Writer, n *Document, entering bool)
+	Paragraph(w io.Writer, n *Paragraph, entering bool)
+	Heading(w io.Writer, n *Heading, entering bool)
+	List(w io.Writer, n *List, entering bool)
+	ListItem(w io.Writer, n *ListItem, entering bool)
+	DefinitionList(w io.Writer, n *DefinitionList, entering bool)
+	DefinitionTerm(w io.Writer, n *DefinitionTerm, entering bool)
+	Definition(w io.Writer, n *Definition, entering bool)
+	Indent(w io.Writer, n *Indent, entering bool)
+	Blockquote(w io.Writer, n *Blockquote, entering bool)
+	Table(w io.Writer, n *Table, entering bool)
+	Row(w io.Writer, n *Row, entering bool)
+	Cell(w io.Writer, n *Cell, entering bool)
+	Link(w io.Writer, n *Link, entering bool)
+	Bold(w io.Writer, n *Bold, entering bool)
+	Italics(w io.Writer, n *Italics, entering bool)
+	Superscript(w io.Writer, n *Superscript, entering bool)
+	Subscript(w io.Writer, n *Subscript, entering bool)
+	Monospace(w io.Writer, n *Monospace, entering bool)
+	Underline(w io.Writer, n *Underline, entering bool)
+	Image(w io.Writer, n *Image)
+	NoWiki(w io.Writer, n *NoWiki)
+	HorizontalRule(w io.Writer, n *HorizontalRule)
+	LineBreak(w io.Writer, n *LineBreak)
+	SoftBreak(w io.Writer, n *SoftBreak)
+	Text(w io.Writer, n *Text)
+	TOC(w io.Writer, n *TOC)
+	HTML(w io.Writer, n *HTML)
+}
+
+// Render walks the tree rooted at n, writing each node to w with r.
+// It returns the first error encountered writing to w.
+func Render(w io.Writer, n Node, r Renderer) error {
+	ew := &errWriter{w: w}
+	render(ew, n, r)
+	return ew.err
+}
+
+// render dispatches n, and then its children, to the matching Renderer method
+func render(w io.Writer, n Node, r Renderer) {
+	switch n := n.(type) {
+	case *Document:
+		r.Document(w, n, true)
+		renderChildren(w, n, r)
+		r.Document(w, n, false)
+	case *Paragraph:
+		r.Paragraph(w, n, true)
+		renderChildren(w, n, r)
+		r.Paragraph(w, n, false)
+	case *Heading:
+		r.Heading(w, n, true)
+		renderChildren(w, n, r)
+		r.Heading(w, n, false)
+	case *List:
+		r.List(w, n, true)
+		renderChildren(w, n, r)
+		r.List(w, n, false)
+	case *ListItem:
+		r.ListItem(w, n, true)
+		renderChildren(w, n, r)
+		r.ListItem(w, n, false)
+	case *DefinitionList:
+		r.DefinitionList(w, n, true)
+		renderChildren(w, n, r)
+		r.DefinitionList(w, n, false)
+	case *DefinitionTerm:
+		r.DefinitionTerm(w, n, true)
+		renderChildren(w, n, r)
+		r.DefinitionTerm(w, n, false)
+	case *Definition:
+		r.Definition(w, n, true)
+		renderChildren(w, n, r)
+		r.Definition(w, n, false)
+	case *Indent:
+		r.Indent(w, n, true)
+		renderChildren(w, n, r)
+		r.Indent(w, n, false)
+	case *Blockquote:
+		r.Blockquote(w, n, true)
+		renderChildren(w, n, r)
+		r.Blockquote(w, n, false)
+	case *Table:
+		r.Table(w, n, true)
+		renderChildren(w, n, r)
+		r.Table(w, n, false)
+	case *Row:
+		r.Row(w, n, true)
+		renderChildren(w, n, r)
+		r.Row(w, n, false)
+	case *Cell:
+		r.Cell(w, n, true)
+		renderChildren(w, n, r)
+		r.Cell(w, n, false)
+	case *Link:
+		r.Link(w, n, true)
+		renderChildren(w, n, r)
+		r.Link(w, n, false)
+	case *Bold:
+		r.Bold(w, n, true)
+		renderChildren(w, n, r)
+		r.Bold(w, n, false)
+	case *Italics:
+		r.Italics(w, n, true)
+		renderChildren(w, n, r)
+		r.Italics(w, n, false)
+	case *Superscript:
+		r.Superscript(w, n, true)
+		renderChildren(w, n, r)
+		r.Superscript(w, n, false)
+	case *Subscript:
+		r.Subscript(w, n, true)
+		renderChildren(w, n, r)
+		r.Subscript(w, n, false)
+	case *Monospace:
+		r.Monospace(w, n, true)
+		renderChildren(w, n, r)
+		r.Monospace(w, n, false)
+	case *Underline:
+		r.Underline(w, n, true)
+		renderChildren(w, n, r)
+		r.Underline(w, n, false)
+	case *Image:
+		r.Image(w, n)
+	case *NoWiki:
+		r.NoWiki(w, n)
+	case *HorizontalRule:
+		r.HorizontalRule(w, n)
+	case *LineBreak:
+		r.LineBreak(w, n)
+	case *SoftBreak:
+		r.SoftBreak(w, n)
+	case *Text:
+		r.Text(w, n)
+	case *TOC:
+		r.TOC(w, n)
+	case *HTML:
+		r.HTML(w, n)
+	}
+}
+
+func renderChildren(w io.Writer, n Container, r Renderer) {
+	for _, child := range n.Children() {
+		render(w, child, r)
+	}
+}
+
+// errWriter remembers the first write error and discards everything written after it,
+// so Renderer methods don't each have to check for errors.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	var n int
+	n, ew.err = ew.w.Write(p)
+	return n, ew.err
+}
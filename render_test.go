@@ -0,0 +1,35 @@
+package cajun
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// anchoredHeadings overrides only the heading output of the html renderer
+type anchoredHeadings struct {
+	*HTMLRenderer
+	count int
+}
+
+func (a *anchoredHeadings) Heading(w io.Writer, n *Heading, entering bool) {
+	if entering {
+		a.count++
+		fmt.Fprintf(w, "<h%d id=\"s%d\">", n.Level, a.count)
+	} else {
+		fmt.Fprintf(w, "</h%d>", n.Level)
+	}
+}
+
+func TestRenderOverride(t *testing.T) {
+	doc, _ := Parse("= One =\n\nsome **text**\n\n== Two ==")
+	var buffer bytes.Buffer
+	if err := Render(&buffer, doc, &anchoredHeadings{HTMLRenderer: &HTMLRenderer{}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "<h1 id=\"s1\"> One </h1><p>some <strong>text</strong></p><h2 id=\"s2\"> Two </h2>"
+	if buffer.String() != expected {
+		t.Errorf("got\n\t%v\nexpected\n\t%v", buffer.String(), expected)
+	}
+}
@@ -0,0 +1,115 @@
+package cajun
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMissingLinkClass is the css class given to links to pages that don't exist.
+const DefaultMissingLinkClass = "new"
+
+// LinkResolver maps the target of an internal link, e.g. "Page Name" from [[Page Name]], to a url.
+// Without one an internal link is relative to the current page, e.g. ./Page%20Name.
+// exists reports whether the page exists, links to missing pages are rendered with a css class so they can be styled differently.
+type LinkResolver interface {
+	ResolveLink(target string) (url string, exists bool)
+}
+
+// LinkResolverFunc adapts a function to a LinkResolver.
+type LinkResolverFunc func(target string) (url string, exists bool)
+
+// ResolveLink calls f(target).
+func (f LinkResolverFunc) ResolveLink(target string) (string, bool) {
+	return f(target)
+}
+
+// WikiResolver is a LinkResolver for a wiki serving its pages under Prefix, e.g. "/wiki/".
+// Targets are made into page names the way MediaWiki does it, with the first letter
+// upper cased and spaces replaced by underscores, so [[internal links]] links to /wiki/Internal_links.
+type WikiResolver struct {
+	Prefix string
+
+	// Exists reports whether a page exists, given its page name. When nil every page is assumed to exist.
+	Exists func(page string) bool
+}
+
+// ResolveLink returns the url of the page for target, and of the anchor in it for a target like Page#section.
+func (r WikiResolver) ResolveLink(target string) (string, bool) {
+	page, fragment := splitFragment(target)
+	page = PageName(page)
+	exists := r.Exists == nil || r.Exists(page)
+	return r.Prefix + escapePage(page, fragment), exists
+}
+
+// relativeLink returns the url of target relative to the current page, for internal links when there is no LinkResolver.
+// It starts with ./ so a page name like Help:Contents isn't read as a url scheme.
+func relativeLink(target string) string {
+	return "./" + escapePage(splitFragment(target))
+}
+
+// splitFragment splits a link target like Page#section at its first #.
+func splitFragment(target string) (page, fragment string) {
+	if i := strings.Index(target, "#"); i >= 0 {
+		return target[:i], target[i+1:]
+	}
+	return target, ""
+}
+
+// escapePage escapes each / separated segment of page for a url path, followed by the #fragment when there is one.
+func escapePage(page, fragment string) string {
+	segments := strings.Split(page, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	href := strings.Join(segments, "/")
+	if fragment != "" {
+		href += "#" + url.PathEscape(fragment)
+	}
+	return href
+}
+
+// PageName normalizes a link target to a page name, e.g. " internal  links" becomes "Internal_links".
+func PageName(target string) string {
+	page := strings.Join(strings.Fields(target), "_")
+	r, size := utf8.DecodeRuneInString(page)
+	if r == utf8.RuneError {
+		return page
+	}
+	return string(unicode.ToUpper(r)) + page[size:]
+}
+
+// ImageResolver maps the location of an image, e.g. "flower.jpg" from {{flower.jpg}}, to its source.
+// Returning an error, a nil source or a source whose url scheme isn't allowed rejects the image,
+// it is rendered as its alt text and the problem is reported as a Warning.
+type ImageResolver interface {
+	ResolveImage(location string) (*ImageSource, error)
+}
+
+// ImageResolverFunc adapts a function to an ImageResolver.
+type ImageResolverFunc func(location string) (*ImageSource, error)
+
+// ResolveImage calls f(location).
+func (f ImageResolverFunc) ResolveImage(location string) (*ImageSource, error) {
+	return f(location)
+}
+
+// ImageSource is where an image is served from, and optionally its size.
+type ImageSource struct {
+	URL    string
+	Width  int    // width in pixels, 0 when unknown
+	Height int    // height in pixels, 0 when unknown
+	SrcSet string // a srcset attribute value, e.g. "flower-2x.jpg 2x", written as is
+}
+
+// Warning is a problem that didn't stop the input being transformed, e.g. an image that was rejected.
+type Warning struct {
+	Pos Pos    // byte offset in the input of the node with the problem
+	Msg string // description of the problem
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("creole:%d: %s", w.Pos, w.Msg)
+}
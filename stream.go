@@ -0,0 +1,206 @@
+package cajun
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// TransformTo reads creole from r and writes the transformed output to w.
+//
+// The input is processed a block at a time, a block ending at a blank line
+// (outside of a nowiki or the body of a <<placeholder>> on a line of its own), and the output for each block is written to w as
+// soon as it is complete. Only the block being transformed is held in memory, along with at most
+// the 1000 lines read ahead looking for the <</name>> closing the body of a placeholder.
+//
+// If the input is malformed the output for the blocks before the problem has
+// been written, and a *ParseError positioned in the whole input is returned.
+//
+// When opts.TOC is set the whole input is read and parsed before anything is written,
+// as a table of contents can come before the headings in it.
+func TransformTo(w io.Writer, r io.Reader, opts Options) error {
+	renderer := opts.renderer()
+	if opts.TOC {
+		return transformWhole(w, r, opts, renderer)
+	}
+	bw := bufio.NewWriter(w)
+	ew := &errWriter{w: bw}
+	doc := newDocument()
+	renderer.Document(ew, doc, true)
+
+	ids := newHeadingIDs(opts)
+	blocks := newBlockScanner(r)
+	for blocks.scan() {
+		block, err := parse(blocks.text(), blocks.offset, opts)
+		ids.assign(block)
+		renderChildren(ew, block, renderer)
+		if perr, ok := err.(*ParseError); ok {
+			perr.Offset += blocks.offset
+			perr.Line += blocks.line - 1
+			bw.Flush()
+			return perr
+		}
+		if ew.err != nil {
+			return ew.err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	if blocks.err != nil {
+		return blocks.err
+	}
+	renderer.Document(ew, doc, false)
+	if ew.err != nil {
+		return ew.err
+	}
+	return bw.Flush()
+}
+
+// transformWhole parses all of r before rendering it to w
+func transformWhole(w io.Writer, r io.Reader, opts Options, renderer Renderer) error {
+	input, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	doc, perr := ParseWithOptions(string(input), opts)
+	bw := bufio.NewWriter(w)
+	if err := Render(bw, doc, renderer); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return perr
+}
+
+// blockScanner splits creole input into runs of lines that can be parsed on their own.
+// Each run is one or more lines up to and including the blank lines that end it,
+// blank lines inside a {{{ nowiki }}} don't end a run.
+type blockScanner struct {
+	r        *bufio.Reader
+	block    []string // the lines of the current block
+	pending  []string // lines read ahead, that are scanned before reading any more
+	offset   int      // byte offset of the current block in the input
+	line     int      // line number of the first line of the current block
+	nextOff  int
+	nextLine int
+	inNoWiki bool
+	preBlock bool   // the current line is in a {{{ block }}}, which only a }}} line closes
+	macro    string // the <</name>> closing the block placeholder the current line is in, if any
+	macroAt  int    // the index in block of the line of the block placeholder
+	err      error
+}
+
+func newBlockScanner(r io.Reader) *blockScanner {
+	return &blockScanner{r: bufio.NewReader(r), nextLine: 1}
+}
+
+// scan advances to the next block, it returns false at the end of the input or on a read error
+func (b *blockScanner) scan() bool {
+	b.block = b.block[:0]
+	b.offset = b.nextOff
+	b.line = b.nextLine
+	sawBlank := false
+	for {
+		line, err := b.readLine()
+		if line != "" {
+			blank := isBlank(line)
+			if blank && !b.inNoWiki && !b.preBlock && b.macro == "" && len(b.block) > 0 {
+				sawBlank = true
+			} else if sawBlank && !blank {
+				b.pending = append([]string{line}, b.pending...)
+				return true
+			}
+			b.add(line)
+		}
+		if b.macro != "" && (err == io.EOF || len(b.block)-b.macroAt > maxPlaceholderBodyLines+1) {
+			//the placeholder isn't closed, so it doesn't have a body, the lines following it are scanned again
+			b.unread(b.block[b.macroAt+1:])
+			b.block = b.block[:b.macroAt+1]
+			b.macro = ""
+			continue
+		}
+		if err == io.EOF {
+			return len(b.block) > 0
+		}
+		if err != nil {
+			b.err = err
+			return false
+		}
+	}
+}
+
+// readLine returns the next line, a line read ahead before any more of the input
+func (b *blockScanner) readLine() (string, error) {
+	if len(b.pending) > 0 {
+		line := b.pending[0]
+		b.pending = b.pending[1:]
+		return line, nil
+	}
+	return b.r.ReadString('\n')
+}
+
+// unread puts lines taken off the end of the current block back, to be read again
+func (b *blockScanner) unread(lines []string) {
+	for _, line := range lines {
+		b.nextOff -= len(line)
+		b.nextLine--
+	}
+	b.pending = append(append([]string(nil), lines...), b.pending...)
+}
+
+// add appends line to the current block, keeping track of whether it leaves a nowiki, or the body of a block placeholder, open
+func (b *blockScanner) add(line string) {
+	b.block = append(b.block, line)
+	b.nextOff += len(line)
+	b.nextLine++
+	//blank lines in the body of a block placeholder don't end a block either
+	if b.macro != "" {
+		if strings.TrimSpace(line) == b.macro {
+			b.macro = ""
+		}
+		return
+	}
+	if b.preBlock {
+		b.preBlock = strings.TrimRight(line, " \t\r\n") != "}}}"
+		return
+	}
+	if trimmed := strings.TrimSpace(line); !b.inNoWiki && strings.HasPrefix(trimmed, "{{{") {
+		if _, ok := noWikiLang(trimmed[len("{{{"):]); ok {
+			b.preBlock = true
+			return
+		}
+	}
+	if !b.inNoWiki {
+		if name, _ := parsePlaceholder(strings.TrimSpace(line)); name != "" && isPlaceholderLine(line) && lookupMacro(name) != nil {
+			b.macro = "<</" + name + placeholderDelimRightToken
+			b.macroAt = len(b.block) - 1
+			return
+		}
+	}
+	for {
+		delim := "{{{"
+		if b.inNoWiki {
+			delim = "}}}"
+		}
+		i := strings.Index(line, delim)
+		if i < 0 {
+			return
+		}
+		b.inNoWiki = !b.inNoWiki
+		line = line[i+len(delim):]
+	}
+}
+
+// isPlaceholderLine checks if line is just a <<placeholder>>
+func isPlaceholderLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, placeholderDelimLeftToken) && strings.Index(line, placeholderDelimRightToken) == len(line)-len(placeholderDelimRightToken)
+}
+
+// text returns the current block
+func (b *blockScanner) text() string {
+	return strings.Join(b.block, "")
+}
@@ -0,0 +1,112 @@
+package cajun
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// writeRecorder keeps each write separately, to check output is written as blocks complete
+type writeRecorder struct {
+	writes []string
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestTransformTo(t *testing.T) {
+	input := "= Title =\n\nsome **text**\n\n{{{\nno\n\nwiki\n}}}\n\n* a\n* b"
+	var w writeRecorder
+	if err := TransformTo(&w, strings.NewReader(input), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"<h1> Title </h1>",
+		"<p>some <strong>text</strong></p>",
+		"<pre>no\n\nwiki</pre>",
+		"<ul><li> a</li><li> b</li></ul>",
+	}
+	if strings.Join(w.writes, "|") != strings.Join(expected, "|") {
+		t.Errorf("got\n\t%q\nexpected\n\t%q", w.writes, expected)
+	}
+}
+
+// TestTransformMatchesParse checks that transforming the input a block at a time gives the same output as rendering the whole document
+func TestTransformMatchesParse(t *testing.T) {
+	for _, input := range []string{
+		"**\n \nB",
+		"a\n\t\nb",
+		"* a\n  \r\n}}}",
+		"//a\n \t \n**b//**",
+		"a\r\n\u00a0\r\nb",
+		"{{{\na\n \n}}}\n \nb",
+		"<<box>>\na\n \nb\n<</box>>\n \nc",
+		"|a|\n \n|b|",
+	} {
+		doc, err := Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var whole bytes.Buffer
+		if err := Render(&whole, doc, &HTMLRenderer{}); err != nil {
+			t.Fatal(err)
+		}
+		output, err := Transform(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != whole.String() {
+			t.Errorf("%q: Transform gave\n\t%q\nParse and Render gave\n\t%q", input, output, whole.String())
+		}
+	}
+}
+
+func TestTransformToPlaceholders(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"without a body", "<<date>>\n\na\n\nb", []string{"<p>2026-10-17</p>", "<p>a</p>", "<p>b</p>"}},
+		{"body", "<<box>>\na\n\nb\n<</box>>\n\nc", []string{"<p>a</p><p>b</p>", "<p>c</p>"}},
+		{"unclosed body", "<<box>>\na\n\nb", []string{"<p>a</p>", "<p>b</p>"}},
+		{"unknown", "<<unknown>>\na\n\nb\n<</unknown>>", []string{"<p>&lt;&lt;unknown&gt;&gt;\na</p>", "<p>b\n&lt;&lt;/unknown&gt;&gt;</p>"}},
+	} {
+		var w writeRecorder
+		if err := TransformTo(&w, strings.NewReader(test.input), Options{}); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(w.writes, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%s: got\n\t%q\nexpected\n\t%q", test.name, w.writes, test.expected)
+		}
+	}
+}
+
+func TestTransformToPlaceholderBodyTooLong(t *testing.T) {
+	paragraphs := maxPlaceholderBodyLines/2 + 1
+	input := "<<box>>\n" + strings.Repeat("a\n\n", paragraphs) + "<</box>>"
+	var w writeRecorder
+	if err := TransformTo(&w, strings.NewReader(input), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.writes) != paragraphs+1 || w.writes[paragraphs] != "<p>&lt;&lt;/box&gt;&gt;</p>" {
+		t.Errorf("got %d writes ending with %q, expected %d ending with the <</box>>", len(w.writes), w.writes[len(w.writes)-1], paragraphs+1)
+	}
+}
+
+func TestTransformToError(t *testing.T) {
+	var buffer bytes.Buffer
+	err := TransformTo(&buffer, strings.NewReader("one\n\ntwo\n\n  three {{{ four"), Options{})
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected a *ParseError, got %v", err)
+	}
+	if perr.Offset != 18 || perr.Line != 5 || perr.Column != 9 {
+		t.Errorf("got %+v, expected offset 18, line 5, column 9", *perr)
+	}
+	if buffer.String() != "<p>one</p><p>two</p><p>three </p>" {
+		t.Errorf("unexpected output %q", buffer.String())
+	}
+}
@@ -0,0 +1,51 @@
+package cajun
+
+import (
+	"strings"
+)
+
+// TOCEntry is a heading in the outline of a document, with the headings below it nested in Children.
+type TOCEntry struct {
+	Level    int
+	Text     string
+	ID       string // empty unless heading ids are enabled
+	Pos      Pos    // byte offset of the heading in the input
+	Children []*TOCEntry
+}
+
+// TableOfContents returns the outline of doc, each heading nested under the closest heading before it with a lower level.
+func TableOfContents(doc *Document) []*TOCEntry {
+	var toc []*TOCEntry
+	var open []*TOCEntry // the last entry at each depth
+	for _, n := range doc.Nodes {
+		h, ok := n.(*Heading)
+		if !ok {
+			continue
+		}
+		entry := &TOCEntry{Level: h.Level, Text: strings.TrimSpace(plainText(h)), ID: h.ID, Pos: h.Pos}
+		for len(open) > 0 && open[len(open)-1].Level >= entry.Level {
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			toc = append(toc, entry)
+		} else {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		open = append(open, entry)
+	}
+	return toc
+}
+
+// fillTOC sets the entries of each <<toc>> in doc
+func fillTOC(doc *Document) {
+	var toc []*TOCEntry
+	for _, n := range doc.Nodes {
+		if t, ok := n.(*TOC); ok {
+			if toc == nil {
+				toc = TableOfContents(doc)
+			}
+			t.Entries = toc
+		}
+	}
+}
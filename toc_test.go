@@ -0,0 +1,54 @@
+package cajun
+
+import (
+	"fmt"
+	"testing"
+)
+
+// outline prints toc entries compactly, e.g. [1 Intro #intro @0 [2 Details #details @10 []]]
+func outline(entries []*TOCEntry) string {
+	var s string
+	for _, e := range entries {
+		s += fmt.Sprintf("[%d %s #%s @%d %s]", e.Level, e.Text, e.ID, e.Pos, outline(e.Children))
+	}
+	return s
+}
+
+func TestTableOfContents(t *testing.T) {
+	doc, err := ParseWithOptions("== Intro ==\ntext\n=== A\n==== A.1\n=== B\n= Top **level**\n== Intro", Options{HeadingIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[2 Intro #intro @0 [3 A #a @17 [4 A.1 #a-1 @23 ]][3 B #b @32 ]][1 Top level #top-level @38 [2 Intro #intro-1 @54 ]]"
+	if got := outline(TableOfContents(doc)); got != expected {
+		t.Errorf("got\n\t%v\nexpected\n\t%v", got, expected)
+	}
+}
+
+func TestTableOfContentsWithoutIDs(t *testing.T) {
+	doc, _ := Parse("= a\n== b")
+	if got := outline(TableOfContents(doc)); got != "[1 a # @0 [2 b # @4 ]]" {
+		t.Errorf("unexpected outline %v", got)
+	}
+}
+
+var tocTests = []optionsTest{
+	{"toc", "<<toc>>\n= A =\n== B ==\n\n= C =", Options{TOC: true}, "<ul class=\"toc\"><li><a href=\"#a\">A</a><ul><li><a href=\"#b\">B</a></li></ul></li><li><a href=\"#c\">C</a></li></ul><h1 id=\"a\"> A </h1><h2 id=\"b\"> B </h2><h1 id=\"c\"> C </h1>"},
+	{"toc ends a paragraph", "text\n<< TOC >>\n= A", Options{TOC: true}, "<p>text</p><ul class=\"toc\"><li><a href=\"#a\">A</a></li></ul><h1 id=\"a\"> A</h1>"},
+	{"toc escapes", "<<toc>>\n= a<b", Options{TOC: true}, "<ul class=\"toc\"><li><a href=\"#a-b\">a&lt;b</a></li></ul><h1 id=\"a-b\"> a&lt;b</h1>"},
+	{"toc without headings", "<<toc>>", Options{TOC: true}, ""},
+	{"toc off", "<<toc>>", Options{}, "<p>&lt;&lt;toc&gt;&gt;</p>"},
+	{"other placeholder", "<<other>>", Options{TOC: true}, "<p>&lt;&lt;other&gt;&gt;</p>"},
+}
+
+func TestTOC(t *testing.T) {
+	for _, test := range tocTests {
+		output, err := TransformWithOptions(test.input, test.opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.output != output {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, output, test.output)
+		}
+	}
+}
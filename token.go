@@ -0,0 +1,239 @@
+package cajun
+
+import (
+	"fmt"
+	"iter"
+)
+
+// TokenType identifies the kind of a Token.
+// The values are stable, new types are only ever added at the end.
+type TokenType int
+
+const (
+	TokenError                   TokenType = iota + 1 // malformed input, Val is the description of the problem
+	TokenEOF                                          // end of the input
+	TokenText                                         // plain text
+	TokenSpaceRun                                     // a run of spaces and tabs
+	TokenNewLine                                      // \n or \r\n
+	TokenBold                                         // ** opening or closing bold
+	TokenItalics                                      // // opening or closing italics
+	TokenEscape                                       // ~
+	TokenEscapeText                                   // the text following a ~, or a ~ escaping nothing
+	TokenFreeLink                                     // a url in the text, e.g. http://example.com
+	TokenLink                                         // [[location|text]]
+	TokenImage                                        // {{location|alt}}
+	TokenWikiLineBreak                                // \\
+	TokenHorizontalRule                               // ----
+	TokenHeading1                                     // = at the start of a line
+	TokenHeading2                                     // ==
+	TokenHeading3                                     // ===
+	TokenHeading4                                     // ====
+	TokenHeading5                                     // =====
+	TokenHeading6                                     // ======
+	TokenHeadingCloseRun                              // the optional = run ending a heading
+	TokenListUnordered                                // the item following a TokenListUnorderedIncrease, Val is empty
+	TokenListUnorderedIncrease                        // * starting a list one deeper than the last
+	TokenListUnorderedSameAsLast                      // * starting an item at the same depth as the last
+	TokenListUnorderedDecrease                        // * starting an item shallower than the last
+	TokenListOrdered                                  // the item following a TokenListOrderedIncrease, Val is empty
+	TokenListOrderedIncrease                          // # starting a list one deeper than the last
+	TokenListOrderedSameAsLast                        // # starting an item at the same depth as the last
+	TokenListOrderedDecrease                          // # starting an item shallower than the last
+	TokenTableRowStart                                // | at the start of a line
+	TokenTableRowEnd                                  // | at the end of a line
+	TokenTableItem                                    // a cell, Val is the | starting it, empty following TokenTableRowStart, and any ExtensionTableCells markers
+	TokenTableHeaderItem                              // a header cell, Val is the |= starting it, or = following TokenTableRowStart, and any ExtensionTableCells markers
+	TokenNoWikiOpen                                   // {{{
+	TokenNoWikiText                                   // the text of a nowiki
+	TokenNoWikiClose                                  // }}}
+	TokenPlaceholder                                  // <<name args>>
+	TokenPlaceholderBlockOpen                         // <<name args>> of a registered macro on a line of its own, starting a body
+	TokenPlaceholderBlockBody                         // the lines between a TokenPlaceholderBlockOpen and TokenPlaceholderBlockClose
+	TokenPlaceholderBlockClose                        // <</name>> ending the body
+	TokenSuperscript                                  // ^^ opening or closing superscript, with ExtensionAdditions
+	TokenSubscript                                    // ,, opening or closing subscript, with ExtensionAdditions
+	TokenMonospace                                    // ## opening or closing monospace, with ExtensionAdditions
+	TokenUnderline                                    // __ opening or closing underline, with ExtensionAdditions
+	TokenNoWikiBlockOpen                              // {{{ on a line of its own, starting a preformatted block, or {{{#!lang giving its language
+	TokenNoWikiBlockClose                             // }}} on a line of its own, ending a preformatted block
+	TokenWikiWord                                     // a WikiWord linking to the page of that name, with ExtensionWikiWords
+	TokenDefinitionTerm                               // ; starting the term of a definition list, with ExtensionDefinitionLists
+	TokenDefinition                                   // : starting a definition, at the start of a line or following the term, with ExtensionDefinitionLists
+	TokenIndent                                       // the run of : at the start of an indented line, with ExtensionIndent
+	TokenBlockquote                                   // the run of > at the start of a quoted line, with ExtensionBlockquotes
+)
+
+var tokenNames = map[TokenType]string{
+	TokenError:                   "error",
+	TokenEOF:                     "EOF",
+	TokenText:                    "text",
+	TokenSpaceRun:                "spaces",
+	TokenNewLine:                 "newline",
+	TokenBold:                    "bold",
+	TokenItalics:                 "italics",
+	TokenEscape:                  "escape",
+	TokenEscapeText:              "escapetext",
+	TokenFreeLink:                "freelink",
+	TokenLink:                    "link",
+	TokenImage:                   "image",
+	TokenWikiLineBreak:           "wikilinebreak",
+	TokenHorizontalRule:          "horizontalrule",
+	TokenHeading1:                "heading1",
+	TokenHeading2:                "heading2",
+	TokenHeading3:                "heading3",
+	TokenHeading4:                "heading4",
+	TokenHeading5:                "heading5",
+	TokenHeading6:                "heading6",
+	TokenHeadingCloseRun:         "headingcloserun",
+	TokenListUnordered:           "listunordered",
+	TokenListUnorderedIncrease:   "listunorderedincrease",
+	TokenListUnorderedSameAsLast: "listunorderedsameaslast",
+	TokenListUnorderedDecrease:   "listunordereddecrease",
+	TokenListOrdered:             "listordered",
+	TokenListOrderedIncrease:     "listorderedincrease",
+	TokenListOrderedSameAsLast:   "listorderedsameaslast",
+	TokenListOrderedDecrease:     "listordereddecrease",
+	TokenTableRowStart:           "tablerowstart",
+	TokenTableRowEnd:             "tablerowend",
+	TokenTableItem:               "tableitem",
+	TokenTableHeaderItem:         "tableheaderitem",
+	TokenNoWikiOpen:              "nowikiopen",
+	TokenNoWikiText:              "nowikitext",
+	TokenNoWikiClose:             "nowikiclose",
+	TokenPlaceholder:             "placeholder",
+	TokenPlaceholderBlockOpen:    "placeholderblockopen",
+	TokenPlaceholderBlockBody:    "placeholderblockbody",
+	TokenPlaceholderBlockClose:   "placeholderblockclose",
+	TokenSuperscript:             "superscript",
+	TokenSubscript:               "subscript",
+	TokenMonospace:               "monospace",
+	TokenUnderline:               "underline",
+	TokenNoWikiBlockOpen:         "nowikiblockopen",
+	TokenNoWikiBlockClose:        "nowikiblockclose",
+	TokenWikiWord:                "wikiword",
+	TokenDefinitionTerm:          "definitionterm",
+	TokenDefinition:              "definition",
+	TokenIndent:                  "indent",
+	TokenBlockquote:              "blockquote",
+}
+
+func (t TokenType) String() string {
+	if s, ok := tokenNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("token%d", int(t))
+}
+
+// tokenTypes maps the lexer's item types to the exported token types
+var tokenTypes = map[itemType]TokenType{
+	itemError:                   TokenError,
+	itemEOF:                     TokenEOF,
+	itemText:                    TokenText,
+	itemSpaceRun:                TokenSpaceRun,
+	itemNewLine:                 TokenNewLine,
+	itemBold:                    TokenBold,
+	itemItalics:                 TokenItalics,
+	itemEscape:                  TokenEscape,
+	itemEscapeText:              TokenEscapeText,
+	itemFreeLink:                TokenFreeLink,
+	itemLink:                    TokenLink,
+	itemImage:                   TokenImage,
+	itemWikiLineBreak:           TokenWikiLineBreak,
+	itemHorizontalRule:          TokenHorizontalRule,
+	itemHeading1:                TokenHeading1,
+	itemHeading2:                TokenHeading2,
+	itemHeading3:                TokenHeading3,
+	itemHeading4:                TokenHeading4,
+	itemHeading5:                TokenHeading5,
+	itemHeading6:                TokenHeading6,
+	itemHeadingCloseRun:         TokenHeadingCloseRun,
+	itemListUnordered:           TokenListUnordered,
+	itemListUnorderedIncrease:   TokenListUnorderedIncrease,
+	itemListUnorderedSameAsLast: TokenListUnorderedSameAsLast,
+	itemListUnorderedDecrease:   TokenListUnorderedDecrease,
+	itemListOrdered:             TokenListOrdered,
+	itemListOrderedIncrease:     TokenListOrderedIncrease,
+	itemListOrderedSameAsLast:   TokenListOrderedSameAsLast,
+	itemListOrderedDecrease:     TokenListOrderedDecrease,
+	itemTableRowStart:           TokenTableRowStart,
+	itemTableRowEnd:             TokenTableRowEnd,
+	itemTableItem:               TokenTableItem,
+	itemTableHeaderItem:         TokenTableHeaderItem,
+	itemNoWikiOpen:              TokenNoWikiOpen,
+	itemNoWikiText:              TokenNoWikiText,
+	itemNoWikiClose:             TokenNoWikiClose,
+	itemPlaceholder:             TokenPlaceholder,
+	itemPlaceholderBlockOpen:    TokenPlaceholderBlockOpen,
+	itemPlaceholderBlockBody:    TokenPlaceholderBlockBody,
+	itemPlaceholderBlockClose:   TokenPlaceholderBlockClose,
+	itemSuperscript:             TokenSuperscript,
+	itemSubscript:               TokenSubscript,
+	itemMonospace:               TokenMonospace,
+	itemUnderline:               TokenUnderline,
+	itemNoWikiBlockOpen:         TokenNoWikiBlockOpen,
+	itemNoWikiBlockClose:        TokenNoWikiBlockClose,
+	itemWikiWord:                TokenWikiWord,
+	itemDefinitionTerm:          TokenDefinitionTerm,
+	itemDefinition:              TokenDefinition,
+	itemIndent:                  TokenIndent,
+	itemBlockquote:              TokenBlockquote,
+}
+
+// Token is a lexeme of creole markup, e.g. the ** opening bold text.
+type Token struct {
+	Type TokenType
+	Pos  Pos    // byte offset of the start of the token in the input
+	Val  string // the input the token covers
+}
+
+// Placeholder returns the name and arguments of a TokenPlaceholder or TokenPlaceholderBlockOpen, e.g. <<include page=Intro>>.
+// ok is false for other tokens.
+func (t Token) Placeholder() (name string, args MacroArgs, ok bool) {
+	if t.Type != TokenPlaceholder && t.Type != TokenPlaceholderBlockOpen {
+		return "", nil, false
+	}
+	name, raw := parsePlaceholder(t.Val)
+	return name, parseMacroArgs(raw), true
+}
+
+func (t Token) String() string {
+	return fmt.Sprintf("%v %q", t.Type, t.Val)
+}
+
+// Lexer splits creole into tokens, the same ones the parser works from.
+type Lexer struct {
+	l *lexer
+}
+
+// NewLexer returns a Lexer for input.
+func NewLexer(input string) *Lexer {
+	return NewLexerWithExtensions(input, 0)
+}
+
+// NewLexerWithExtensions returns a Lexer for input that also produces the tokens of the syntax extensions in ext.
+func NewLexerWithExtensions(input string, ext Extension) *Lexer {
+	l := lex("creole", input)
+	l.extensions = ext
+	return &Lexer{l: l}
+}
+
+// Next returns the next token. The last token is TokenEOF, or TokenError if
+// the input is malformed, after which Next keeps returning TokenEOF.
+func (l *Lexer) Next() Token {
+	item := l.l.nextItem()
+	return Token{Type: tokenTypes[item.typ], Pos: Pos(item.pos), Val: item.val}
+}
+
+// Tokens returns the tokens of input, up to but not including the TokenEOF.
+// A TokenError is the last token of malformed input.
+func Tokens(input string) iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		l := NewLexer(input)
+		for {
+			t := l.Next()
+			if t.Type == TokenEOF || !yield(t) || t.Type == TokenError {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package cajun
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	var tokens []Token
+	for token := range Tokens("== Title\n* **a** [[b]]") {
+		tokens = append(tokens, token)
+	}
+	expected := []Token{
+		{TokenHeading2, 0, "=="},
+		{TokenText, 2, " Title"},
+		{TokenNewLine, 8, "\n"},
+		{TokenListUnorderedIncrease, 9, "*"},
+		{TokenListUnordered, 10, ""},
+		{TokenText, 10, " "},
+		{TokenBold, 11, "**"},
+		{TokenText, 13, "a"},
+		{TokenBold, 14, "**"},
+		{TokenText, 16, " "},
+		{TokenLink, 17, "[[b]]"},
+	}
+	if fmt.Sprint(tokens) != fmt.Sprint(expected) {
+		t.Errorf("got\n\t%v\nexpected\n\t%v", tokens, expected)
+	}
+	for i := range expected {
+		if i < len(tokens) && tokens[i].Pos != expected[i].Pos {
+			t.Errorf("token %d: got position %d expected %d", i, tokens[i].Pos, expected[i].Pos)
+		}
+	}
+}
+
+func TestTokensError(t *testing.T) {
+	var tokens []Token
+	for token := range Tokens("a [[b") {
+		tokens = append(tokens, token)
+	}
+	if len(tokens) != 2 || tokens[1].Type != TokenError {
+		t.Errorf("expected text then an error, got %v", tokens)
+	}
+}
+
+func TestLexerNext(t *testing.T) {
+	l := NewLexer("a")
+	for _, expected := range []TokenType{TokenText, TokenEOF, TokenEOF} {
+		if token := l.Next(); token.Type != expected {
+			t.Errorf("got %v expected %v", token.Type, expected)
+		}
+	}
+}
+
+func TestLexerWithExtensions(t *testing.T) {
+	l := NewLexerWithExtensions("##a##", ExtensionAdditions)
+	for _, expected := range []TokenType{TokenMonospace, TokenText, TokenMonospace, TokenEOF} {
+		if token := l.Next(); token.Type != expected {
+			t.Errorf("got %v expected %v", token.Type, expected)
+		}
+	}
+}
+
+func TestTokenTypeString(t *testing.T) {
+	for typ, expected := range map[TokenType]string{
+		TokenHeading1:    "heading1",
+		TokenNoWikiClose: "nowikiclose",
+		TokenType(999):   "token999",
+	} {
+		if typ.String() != expected {
+			t.Errorf("got %q expected %q", typ.String(), expected)
+		}
+	}
+	for _, typ := range tokenTypes {
+		if _, ok := tokenNames[typ]; !ok {
+			t.Errorf("%v has no name", typ)
+		}
+	}
+}